@@ -34,6 +34,7 @@ func main() {
 		// Provide service modules
 		fx.Provide(
 			auth.NewService,
+			index.NewSearchBackend,
 			index.NewService,
 			p2p.NewService,
 		),
@@ -43,10 +44,16 @@ func main() {
 			api.NewAuthHandler,
 			api.NewIndexHandler,
 			api.NewP2PHandler,
+			api.NewFederationHandler,
 			api.NewRouter,
 			api.NewServer,
 		),
 
+		// Notify saved searches of newly shared files
+		fx.Invoke(func(p2pSvc *p2p.Service, indexSvc *index.Service) {
+			p2pSvc.OnFileShared(indexSvc.NotifyMatches)
+		}),
+
 		// Invoke server start
 		fx.Invoke(func(server *api.Server, lc fx.Lifecycle) {
 			server.Start(lc)
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/inventor7/p2p/internal/config"
+	"github.com/inventor7/p2p/internal/p2p"
+	"go.uber.org/zap"
+)
+
+// FederationHandler handles requests exchanged between super-peer servers
+type FederationHandler struct {
+	cfg     *config.Config
+	logger  *zap.Logger
+	service *p2p.Service
+}
+
+// NewFederationHandler creates a new federation handler instance
+func NewFederationHandler(cfg *config.Config, logger *zap.Logger, service *p2p.Service) *FederationHandler {
+	return &FederationHandler{
+		cfg:     cfg,
+		logger:  logger,
+		service: service,
+	}
+}
+
+// Register handles a neighbour server registering (or refreshing) its presence.
+// The response carries this server's presence so both sides learn about each other.
+func (h *FederationHandler) Register(c *gin.Context) {
+	var req p2p.FederationPresence
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if req.ServerID == "" || req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "server_id and url are required"})
+		return
+	}
+
+	h.service.RecordFederationPresence(&req)
+
+	presence, err := h.service.LocalPresence(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to build federation presence", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build presence: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, presence)
+}
+
+// Search handles a search forwarded by a neighbour server
+func (h *FederationHandler) Search(c *gin.Context) {
+	var req p2p.FederatedSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if req.QueryID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query_id is required"})
+		return
+	}
+	if req.Options != nil && req.Options.SpaceID != "" {
+		// Space membership is only known to the server the user searched on
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Space filters cannot be federated"})
+		return
+	}
+
+	resp, err := h.service.HandleFederatedSearch(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, p2p.ErrDuplicateQuery) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Query already handled"})
+			return
+		}
+		h.logger.Error("Failed to handle federated search", zap.Error(err), zap.String("queryID", req.QueryID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files: " + err.Error()})
+		return
+	}
+
+	if resp.Results == nil {
+		resp.Results = []*p2p.FileSearchResult{}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Lookup handles a hash lookup forwarded by a neighbour server
+func (h *FederationHandler) Lookup(c *gin.Context) {
+	var req p2p.FederatedLookupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if req.QueryID == "" || req.Hash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query_id and hash are required"})
+		return
+	}
+
+	resp, err := h.service.HandleFederatedLookup(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, p2p.ErrDuplicateQuery) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Query already handled"})
+			return
+		}
+		h.logger.Error("Failed to handle federated hash lookup", zap.Error(err), zap.String("queryID", req.QueryID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up hash: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetNodes lists the neighbour servers this server currently federates with
+func (h *FederationHandler) GetNodes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"server_id": h.cfg.ServerID,
+		"nodes":     h.service.FederationNodes(),
+	})
+}
+
+// Middleware rejects federation requests that do not carry the shared secret.
+// Without a configured secret federation is disabled and every request is rejected.
+func (h *FederationHandler) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.cfg.FederationSecret == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Federation is disabled on this server"})
+			return
+		}
+
+		secret := c.GetHeader("X-Federation-Secret")
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(h.cfg.FederationSecret)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid federation secret"})
+			return
+		}
+		c.Next()
+	}
+}
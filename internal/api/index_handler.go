@@ -2,7 +2,11 @@ package api
 
 import (
 	"errors" // For gorm.ErrRecordNotFound
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/inventor7/p2p/internal/db"    // Your database models
@@ -252,26 +256,389 @@ func (h *IndexHandler) GetMembers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"members": members})
 }
 
-// SearchFiles handles file search across the P2P network
+// SearchFiles handles GET /api/search/files, searching files across the P2P
+// network. See parseSearchOptions for the filter, sort and paging parameters.
 func (h *IndexHandler) SearchFiles(c *gin.Context) {
-	query := c.Query("q") // Get search query from query parameter "q"
-	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query 'q' is required"})
+	opts, err := parseSearchOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Use p2pService for global file search
-	files, err := h.p2pService.SearchSharedFiles(c.Request.Context(), query)
+	// Use p2pService for global file search, including federated super-peer servers
+	page, err := h.p2pService.SearchNetwork(c.Request.Context(), opts)
 	if err != nil {
-		h.logger.Error("Failed to search files via P2P service", zap.Error(err), zap.String("query", query))
+		if errors.Is(err, index.ErrInvalidCursor) || errors.Is(err, index.ErrInvalidSearchOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to search files via P2P service", zap.Error(err), zap.String("query", opts.Query))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files: " + err.Error()})
 		return
 	}
 
-	if files == nil {
-		files = []*p2p.FileSearchResult{} // Return empty array instead of null if no files found
+	h.logger.Info("File search performed", zap.String("query", opts.Query), zap.Int("results_count", len(page.Files)), zap.Int("total", page.Total))
+	c.JSON(http.StatusOK, page)
+}
+
+// SearchSpaceFiles handles GET /api/search/spaces, searching the files in the
+// spaces the authenticated user is a member of. It takes the same parameters as
+// SearchFiles.
+func (h *IndexHandler) SearchSpaceFiles(c *gin.Context) {
+	userID, exists := c.Get("userID") // From AuthMiddleware
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
 	}
 
-	h.logger.Info("File search performed", zap.String("query", query), zap.Int("results_count", len(files)))
-	c.JSON(http.StatusOK, gin.H{"files": files})
+	opts, err := parseSearchOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Online = h.p2pService.IsOnline
+
+	page, err := h.indexService.SearchFiles(c.Request.Context(), userID.(string), opts)
+	if err != nil {
+		if errors.Is(err, index.ErrInvalidCursor) || errors.Is(err, index.ErrInvalidSearchOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to search space files", zap.Error(err), zap.String("query", opts.Query))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// Autocomplete handles GET /api/search/autocomplete?q=<partial query>&limit=<n>,
+// returning the most common completions of the last term as the user types
+func (h *IndexHandler) Autocomplete(c *gin.Context) {
+	prefix := c.Query("q")
+	if strings.TrimSpace(prefix) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "search prefix 'q' is required"})
+		return
+	}
+	limit := index.DefaultCompletions
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+	}
+
+	completions := h.indexService.Autocomplete(c.Request.Context(), prefix, limit)
+	c.JSON(http.StatusOK, gin.H{"query": prefix, "completions": completions})
+}
+
+// LookupHash handles GET /api/search/hash/:hash, listing every owner of the
+// content with the given hash across the network and the names it is shared under
+func (h *IndexHandler) LookupHash(c *gin.Context) {
+	hash := c.Param("hash")
+	if hash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File hash is required"})
+		return
+	}
+
+	lookup, err := h.p2pService.LookupHash(c.Request.Context(), hash)
+	if err != nil {
+		if errors.Is(err, p2p.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No shared file has this hash"})
+			return
+		}
+		h.logger.Error("Failed to look up hash", zap.Error(err), zap.String("hash", hash))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up hash: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, lookup)
+}
+
+// GetPeerDuplicates handles GET /api/search/duplicates/peers/:id, reporting the
+// content a peer shares more than once
+func (h *IndexHandler) GetPeerDuplicates(c *gin.Context) {
+	peerID := c.Param("id")
+
+	report, err := h.indexService.PeerDuplicates(c.Request.Context(), peerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find duplicates: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"peer_id": peerID, "report": report})
+}
+
+// GetSpaceDuplicates handles GET /api/spaces/:id/duplicates, reporting the content
+// that was added to a space more than once
+func (h *IndexHandler) GetSpaceDuplicates(c *gin.Context) {
+	spaceID := c.Param("id")
+
+	report, err := h.indexService.SpaceDuplicates(c.Request.Context(), spaceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find duplicates: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"space_id": spaceID, "report": report})
+}
+
+// parseSearchOptions reads the search parameters shared by the search endpoints:
+//
+//	q                                full-text query
+//	type, category                   MIME types (image/png, image/*) or categories (image, document, ...), comma-separated
+//	min_size, max_size               size range in bytes
+//	modified_after, modified_before  RFC 3339 timestamps
+//	owner, space                     owning peer ID, shared space ID (space searches only)
+//	online_only                      only files whose owner is connected
+//	sort, order                      relevance|size|date|popularity, asc|desc
+//	cursor, limit                    paging; pass next_cursor from the previous page
+func parseSearchOptions(c *gin.Context) (*index.SearchOptions, error) {
+	opts := &index.SearchOptions{
+		Query:   c.Query("q"),
+		OwnerID: c.Query("owner"),
+		SpaceID: c.Query("space"),
+		Sort:    c.Query("sort"),
+		Cursor:  c.Query("cursor"),
+	}
+
+	for _, value := range c.QueryArray("type") {
+		for _, fileType := range strings.Split(value, ",") {
+			if fileType = strings.TrimSpace(fileType); fileType != "" {
+				opts.Types = append(opts.Types, fileType)
+			}
+		}
+	}
+	for _, value := range c.QueryArray("category") {
+		types, err := categoryTypes(strings.Split(value, ","))
+		if err != nil {
+			return nil, err
+		}
+		opts.Types = append(opts.Types, types...)
+	}
+
+	var err error
+	if value := c.Query("min_size"); value != "" {
+		if opts.MinSize, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("min_size must be a number of bytes")
+		}
+	}
+	if value := c.Query("max_size"); value != "" {
+		if opts.MaxSize, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("max_size must be a number of bytes")
+		}
+	}
+	if value := c.Query("modified_after"); value != "" {
+		if opts.ModifiedAfter, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("modified_after must be an RFC 3339 timestamp")
+		}
+	}
+	if value := c.Query("modified_before"); value != "" {
+		if opts.ModifiedBefore, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("modified_before must be an RFC 3339 timestamp")
+		}
+	}
+	if value := c.Query("online_only"); value != "" {
+		if opts.OnlineOnly, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("online_only must be true or false")
+		}
+	}
+	switch strings.ToLower(c.Query("order")) {
+	case "", "desc":
+	case "asc":
+		opts.Ascending = true
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+	if value := c.Query("limit"); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil || opts.Limit < 1 {
+			return nil, fmt.Errorf("limit must be a positive number")
+		}
+	}
+
+	if opts.Query == "" && len(opts.Types) == 0 && opts.OwnerID == "" && opts.SpaceID == "" {
+		return nil, fmt.Errorf("search query 'q' or a type, owner or space filter is required")
+	}
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// categoryTypes expands file category names into the MIME type patterns they cover
+func categoryTypes(categories []string) ([]string, error) {
+	var types []string
+	for _, category := range categories {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if category == "" {
+			continue
+		}
+		patterns, ok := index.Categories[category]
+		if !ok {
+			return nil, fmt.Errorf("unknown category %q", category)
+		}
+		types = append(types, patterns...)
+	}
+	return types, nil
+}
+
+// --- Saved Searches and Notifications ---
+
+// SaveSearch handles POST /api/saved-searches, saving a query and filters the
+// user is notified about when newly shared files match them
+func (h *IndexHandler) SaveSearch(c *gin.Context) {
+	userID, exists := c.Get("userID") // From AuthMiddleware
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req struct {
+		Name           string     `json:"name" binding:"required"`
+		Query          string     `json:"query"`
+		Types          []string   `json:"types"`      // MIME types; "image/*" matches a whole category
+		Categories     []string   `json:"categories"` // image, video, audio, text, document, archive
+		MinSize        int64      `json:"min_size"`
+		MaxSize        int64      `json:"max_size"`
+		ModifiedAfter  *time.Time `json:"modified_after"`
+		ModifiedBefore *time.Time `json:"modified_before"`
+		OwnerID        string     `json:"owner_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	types, err := categoryTypes(req.Categories)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts := &index.SearchOptions{
+		Query:   req.Query,
+		Types:   append(req.Types, types...),
+		MinSize: req.MinSize,
+		MaxSize: req.MaxSize,
+		OwnerID: req.OwnerID,
+	}
+	if req.ModifiedAfter != nil {
+		opts.ModifiedAfter = *req.ModifiedAfter
+	}
+	if req.ModifiedBefore != nil {
+		opts.ModifiedBefore = *req.ModifiedBefore
+	}
+
+	saved, err := h.indexService.SaveSearch(c.Request.Context(), userID.(string), req.Name, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, index.ErrInvalidSearchOptions):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, index.ErrSavedSearchLimit):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to save search", zap.Error(err), zap.String("userID", userID.(string)))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save search: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"saved_search": saved})
+}
+
+// ListSavedSearches handles GET /api/saved-searches
+func (h *IndexHandler) ListSavedSearches(c *gin.Context) {
+	userID, exists := c.Get("userID") // From AuthMiddleware
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	searches, err := h.indexService.ListSavedSearches(c.Request.Context(), userID.(string))
+	if err != nil {
+		h.logger.Error("Failed to list saved searches", zap.Error(err), zap.String("userID", userID.(string)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list saved searches: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saved_searches": searches})
+}
+
+// DeleteSavedSearch handles DELETE /api/saved-searches/:id
+func (h *IndexHandler) DeleteSavedSearch(c *gin.Context) {
+	userID, exists := c.Get("userID") // From AuthMiddleware
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.indexService.DeleteSavedSearch(c.Request.Context(), userID.(string), c.Param("id")); err != nil {
+		if errors.Is(err, index.ErrSavedSearchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+			return
+		}
+		h.logger.Error("Failed to delete saved search", zap.Error(err), zap.String("userID", userID.(string)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete saved search: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search deleted successfully"})
+}
+
+// ListNotifications handles GET /api/notifications?unread_only=true&limit=<n>,
+// returning the user's new-match notifications, newest first
+func (h *IndexHandler) ListNotifications(c *gin.Context) {
+	userID, exists := c.Get("userID") // From AuthMiddleware
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	unreadOnly, _ := strconv.ParseBool(c.Query("unread_only"))
+	limit := index.DefaultPageSize
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+	}
+
+	notifications, err := h.indexService.ListNotifications(c.Request.Context(), userID.(string), unreadOnly, limit)
+	if err != nil {
+		h.logger.Error("Failed to list notifications", zap.Error(err), zap.String("userID", userID.(string)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list notifications: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications})
+}
+
+// MarkNotificationsRead handles POST /api/notifications/read, marking the
+// notifications with the given IDs as read, or all of them if none are given
+func (h *IndexHandler) MarkNotificationsRead(c *gin.Context) {
+	userID, exists := c.Get("userID") // From AuthMiddleware
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	marked, err := h.indexService.MarkNotificationsRead(c.Request.Context(), userID.(string), req.IDs)
+	if err != nil {
+		h.logger.Error("Failed to mark notifications read", zap.Error(err), zap.String("userID", userID.(string)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications read: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
 }
@@ -16,6 +16,7 @@ type Router struct {
 	authHandler  *AuthHandler
 	indexHandler *IndexHandler
 	p2pHandler   *P2PHandler
+	fedHandler   *FederationHandler
 }
 
 // NewRouter creates a new router instance
@@ -25,6 +26,7 @@ func NewRouter(
 	authHandler *AuthHandler,
 	indexHandler *IndexHandler,
 	p2pHandler *P2PHandler,
+	fedHandler *FederationHandler,
 ) *Router {
 	return &Router{
 		cfg:          cfg,
@@ -32,6 +34,7 @@ func NewRouter(
 		authHandler:  authHandler,
 		indexHandler: indexHandler,
 		p2pHandler:   p2pHandler,
+		fedHandler:   fedHandler,
 	}
 }
 
@@ -65,18 +68,46 @@ func (r *Router) Setup() *gin.Engine {
 		// P2P routes for peer interactions - Public or PeerID based
 		p2p := api.Group("/p2p")
 		{
-			p2p.POST("/join", r.p2pHandler.JoinNetwork)            // Peer announces itself - Public
-			p2p.POST("/leave", r.p2pHandler.LeaveNetwork)          // Peer announces departure - Needs PeerID (via header)
-			p2p.POST("/files/share", r.p2pHandler.ShareFile)       // Peer shares file metadata - Needs PeerID (via header)
-			p2p.GET("/peers", r.p2pHandler.GetPeers)               // List active peers - Public or PeerID based
-			p2p.GET("/peers/:id/files", r.p2pHandler.GetPeerFiles) // Get files for a specific peer ID
-
-			// These are likely for initiating direct P2P, so they might not be actual handlers
+			p2p.POST("/join", r.p2pHandler.JoinNetwork)                                                 // Peer announces itself - Public
+			p2p.POST("/leave", r.p2pHandler.PeerAuth(), r.p2pHandler.LeaveNetwork)                      // Peer announces departure - Needs peer credential
+			p2p.POST("/heartbeat", r.p2pHandler.PeerAuth(), r.p2pHandler.Heartbeat)                     // Peer refreshes liveness - Needs peer credential
+			p2p.POST("/files/share", r.p2pHandler.PeerAuth(), r.p2pHandler.ShareFile)                   // Peer shares file metadata - Needs peer credential
+			p2p.POST("/files/sync", r.p2pHandler.PeerAuth(), r.p2pHandler.SyncLibrary)                  // Peer syncs its whole library or a delta - Needs peer credential
+			p2p.PATCH("/files/:id", r.p2pHandler.PeerAuth(), r.p2pHandler.UpdateSharedFile)             // Peer updates one of its files - Needs peer credential
+			p2p.DELETE("/files/:id", r.p2pHandler.PeerAuth(), r.p2pHandler.UnshareFile)                 // Peer withdraws one of its files - Needs peer credential
+			p2p.GET("/files/:id/proof", r.p2pHandler.GetChunkProof)                                     // Merkle proof for one chunk of a file
+			p2p.POST("/files/:id/preview", r.p2pHandler.PeerAuth(), r.p2pHandler.UploadPreview)         // Peer uploads a preview of one of its files - Needs peer credential
+			p2p.GET("/files/:id/preview", r.p2pHandler.GetPreview)                                      // Serve a file's preview
+			p2p.DELETE("/files/:id/preview", r.p2pHandler.PeerAuth(), r.p2pHandler.DeletePreview)       // Peer removes a preview - Needs peer credential
+			p2p.GET("/peers", r.p2pHandler.GetPeers)                                                    // List active peers - Public or PeerID based
+			p2p.GET("/peers/:id/files", r.p2pHandler.GetPeerFiles)                                      // Get files for a specific peer ID
+			p2p.GET("/events", r.p2pHandler.StreamEvents)                                               // Stream presence and sharing events (SSE)
+			p2p.GET("/swarm/:hash", r.p2pHandler.GetSwarm)                                              // Online peers sharing the same content
+			p2p.POST("/relay", r.p2pHandler.PeerAuth(), r.p2pHandler.RequestRelay)                      // Open a relay session to an unreachable peer - Needs peer credential
+			p2p.GET("/relay", r.p2pHandler.PeerAuth(), r.p2pHandler.GetRelayStatus)                     // Pending relay sessions and relayed traffic - Needs peer credential
+			p2p.POST("/peers/:id/connect", r.p2pHandler.PeerAuth(), r.p2pHandler.ConnectToPeer)         // Start a UDP hole punch to a peer - Needs peer credential
+			p2p.GET("/punch/:session", r.p2pHandler.PeerAuth(), r.p2pHandler.GetPunchSession)           // Poll a hole punch - Needs peer credential
+			p2p.POST("/punch/:session/result", r.p2pHandler.PeerAuth(), r.p2pHandler.ReportPunchResult) // Report whether the punch worked - Needs peer credential
+			p2p.POST("/receipts", r.p2pHandler.PeerAuth(), r.p2pHandler.SubmitReceipt)                  // Report a signed, completed download - Needs peer credential
+			p2p.GET("/stats/files/:hash", r.p2pHandler.GetFileStats)                                    // Download count of a file
+			p2p.GET("/stats/peers/:id", r.p2pHandler.GetPeerStats)                                      // Upload/download totals and ratio of a peer
+			p2p.GET("/stats/popular", r.p2pHandler.GetPopularFiles)                                     // Most downloaded files
+
+			// This is likely for tearing down direct P2P, so it might not be an actual handler
 			// on the super-peer but more conceptual for the client.
-			// p2p.POST("/peers/:id/connect", r.p2pHandler.ConnectToPeer)
 			// p2p.POST("/peers/:id/disconnect", r.p2pHandler.DisconnectPeer)
 		}
 
+		// Federation routes used by other super-peer servers - Needs the federation secret
+		federation := api.Group("/federation")
+		federation.Use(r.fedHandler.Middleware())
+		{
+			federation.POST("/register", r.fedHandler.Register) // Neighbour registers and exchanges presence
+			federation.POST("/search", r.fedHandler.Search)     // Neighbour forwards a search
+			federation.POST("/lookup", r.fedHandler.Lookup)     // Neighbour forwards a hash lookup
+			federation.GET("/nodes", r.fedHandler.GetNodes)     // List known neighbour servers
+		}
+
 		// Index/Search routes
 		// index := api.Group("/index")
 		// {
@@ -85,7 +116,11 @@ func (r *Router) Setup() *gin.Engine {
 
 		searchGroup := api.Group("/search")
 		{
-			searchGroup.GET("/files", r.indexHandler.SearchFiles) // This will be /api/search/files
+			searchGroup.GET("/files", r.indexHandler.SearchFiles)                                       // This will be /api/search/files
+			searchGroup.GET("/spaces", r.authHandler.AuthMiddleware(), r.indexHandler.SearchSpaceFiles) // Search the files in the user's spaces
+			searchGroup.GET("/autocomplete", r.indexHandler.Autocomplete)                               // Completions for a partially typed query
+			searchGroup.GET("/hash/:hash", r.indexHandler.LookupHash)                                   // Every owner and name of a piece of content
+			searchGroup.GET("/duplicates/peers/:id", r.indexHandler.GetPeerDuplicates)                  // Content a peer shares more than once
 		}
 
 		// "Protected" routes using JWT AuthMiddleware would now be for specific
@@ -105,6 +140,20 @@ func (r *Router) Setup() *gin.Engine {
 				spaces.DELETE("/:id/files/:fileId", r.indexHandler.RemoveFile)     // Remove a file from a space
 				spaces.GET("/:id/files", r.indexHandler.GetFiles)                  // List files in a space
 				spaces.GET("/:id/members", r.indexHandler.GetMembers)              // List members of a space
+				spaces.GET("/:id/duplicates", r.indexHandler.GetSpaceDuplicates)   // Content added to a space more than once
+			}
+
+			// Saved searches and the notifications of files newly matching them
+			savedSearches := protected.Group("/saved-searches")
+			{
+				savedSearches.POST("/", r.indexHandler.SaveSearch)             // Save a query and filters
+				savedSearches.GET("/", r.indexHandler.ListSavedSearches)       // List the user's saved searches
+				savedSearches.DELETE("/:id", r.indexHandler.DeleteSavedSearch) // Delete a saved search and its notifications
+			}
+			notifications := protected.Group("/notifications")
+			{
+				notifications.GET("/", r.indexHandler.ListNotifications)          // New matches of the user's saved searches
+				notifications.POST("/read", r.indexHandler.MarkNotificationsRead) // Mark notifications read
 			}
 		}
 	}
@@ -120,8 +169,8 @@ func corsMiddleware(allowedOrigins []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Peer-ID")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Peer-ID, X-Peer-Credential, X-Federation-Secret")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
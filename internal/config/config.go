@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 )
@@ -24,13 +26,70 @@ type Config struct {
 	DBSSLMode  string
 
 	// P2P configuration
-	MaxPeers            int
-	MaxSuperPeers       int
-	HeartbeatInterval   int // seconds
-	ConnectionTimeout   int // seconds
-	MaxFileSize         int64
-	AllowedFileTypes    []string
-	DefaultDownloadPath string
+	MaxPeers         int
+	MaxSuperPeers    int
+	JoinQueueSize    int // Joins allowed to wait for a free slot when the server is full
+	JoinQueueTimeout int // seconds a queued join waits before it is redirected
+
+	// Super-peer election policy
+	SuperPeerElection         bool // When false, peers asking to be super peers are accepted as such on join
+	ElectionInterval          int  // seconds
+	SuperPeerMinUptime        int  // seconds
+	SuperPeerMinBandwidth     int  // kbps
+	SuperPeerRequireReachable bool
+
+	// Reachability probing of peer endpoints
+	ReachabilityProbeEnabled    bool
+	ReachabilityProbeTimeout    int // seconds
+	ReachabilityRecheckInterval int // seconds
+	HeartbeatInterval           int // seconds
+	ConnectionTimeout           int // seconds
+	SessionGracePeriod          int // seconds a restarted server waits for known peers to resume
+	MaxFileSize                 int64
+	AllowedFileTypes            []string
+	DefaultDownloadPath         string
+
+	// Full-text search over shared files: "inverted" (in-memory index) or "sql"
+	SearchBackend string
+	// Typos tolerated per query term by the inverted index, 0 to disable fuzzy matching
+	SearchFuzziness int
+
+	// Searches users save to be notified of new matching files
+	MaxSavedSearches int // per user
+
+	// Previews peers upload for their shared files
+	PreviewDir          string
+	MaxPreviewSize      int64 // bytes
+	AllowedPreviewTypes []string
+
+	// Relay for peers that cannot reach each other directly
+	RelayEnabled        bool
+	RelayPort           int
+	RelayPublicHost     string // Host advertised to peers for the relay, rendezvous and cache; defaults to ServerHost
+	RelayBandwidthLimit int64  // bytes per second each peer may push through the relay, 0 for unlimited
+	RelaySessionTimeout int    // seconds both peers have to connect to a relay session
+
+	// UDP rendezvous for hole punching between NATed peers
+	RendezvousEnabled bool
+	RendezvousPort    int
+	PunchTimeout      int // seconds peers have to report the outcome of a punch
+
+	// Content cache letting a super peer seed popular files
+	CacheEnabled          bool
+	CacheDir              string
+	CacheMaxBytes         int64
+	CachePort             int // Port cached files are served on to other peers
+	CacheInterval         int // seconds between cache fills
+	CacheMinPopularity    int // Demand a file needs before it is cached
+	CacheFetchPerInterval int
+
+	// Federation configuration
+	ServerID               string   // Identifies this super-peer server to the federation
+	PublicURL              string   // Base URL other servers use to reach this one
+	FederationPeers        []string // Base URLs of neighbour servers to register with
+	FederationSecret       string   // Shared secret expected on federation requests; federation is off without it
+	FederationSyncInterval int      // seconds
+	FederationHopLimit     int      // How many servers a search may be forwarded through
 
 	// JWT configuration
 	JWTExpiration int // hours
@@ -48,14 +107,45 @@ func NewConfig(logger *zap.Logger) (*Config, error) {
 	dbPort, _ := strconv.Atoi(getEnvOrDefault("DB_PORT", "3306"))
 	maxPeers, _ := strconv.Atoi(getEnvOrDefault("MAX_PEERS", "100"))
 	maxSuperPeers, _ := strconv.Atoi(getEnvOrDefault("MAX_SUPER_PEERS", "10"))
+	joinQueueSize, _ := strconv.Atoi(getEnvOrDefault("JOIN_QUEUE_SIZE", "20"))
+	joinQueueTimeout, _ := strconv.Atoi(getEnvOrDefault("JOIN_QUEUE_TIMEOUT", "5"))
+	superPeerElection, _ := strconv.ParseBool(getEnvOrDefault("SUPER_PEER_ELECTION", "true"))
+	electionInterval, _ := strconv.Atoi(getEnvOrDefault("ELECTION_INTERVAL", "60"))
+	superPeerMinUptime, _ := strconv.Atoi(getEnvOrDefault("SUPER_PEER_MIN_UPTIME", "600"))
+	superPeerMinBandwidth, _ := strconv.Atoi(getEnvOrDefault("SUPER_PEER_MIN_BANDWIDTH", "1024"))
+	superPeerRequireReachable, _ := strconv.ParseBool(getEnvOrDefault("SUPER_PEER_REQUIRE_REACHABLE", "true"))
+	probeEnabled, _ := strconv.ParseBool(getEnvOrDefault("REACHABILITY_PROBE_ENABLED", "true"))
+	probeTimeout, _ := strconv.Atoi(getEnvOrDefault("REACHABILITY_PROBE_TIMEOUT", "3"))
+	probeRecheck, _ := strconv.Atoi(getEnvOrDefault("REACHABILITY_RECHECK_INTERVAL", "600"))
 	heartbeat, _ := strconv.Atoi(getEnvOrDefault("HEARTBEAT_INTERVAL", "30"))
 	timeout, _ := strconv.Atoi(getEnvOrDefault("CONNECTION_TIMEOUT", "60"))
+	sessionGrace, _ := strconv.Atoi(getEnvOrDefault("SESSION_GRACE_PERIOD", "120"))
 	maxFileSize, _ := strconv.ParseInt(getEnvOrDefault("MAX_FILE_SIZE", "1073741824"), 10, 64) // 1GB default
+	relayEnabled, _ := strconv.ParseBool(getEnvOrDefault("RELAY_ENABLED", "false"))
+	relayPort, _ := strconv.Atoi(getEnvOrDefault("RELAY_PORT", "9000"))
+	relayBandwidth, _ := strconv.ParseInt(getEnvOrDefault("RELAY_BANDWIDTH_LIMIT", "524288"), 10, 64) // 512KB/s default
+	relayTimeout, _ := strconv.Atoi(getEnvOrDefault("RELAY_SESSION_TIMEOUT", "30"))
+	rendezvousEnabled, _ := strconv.ParseBool(getEnvOrDefault("RENDEZVOUS_ENABLED", "false"))
+	rendezvousPort, _ := strconv.Atoi(getEnvOrDefault("RENDEZVOUS_PORT", "9001"))
+	punchTimeout, _ := strconv.Atoi(getEnvOrDefault("PUNCH_TIMEOUT", "30"))
+	cacheEnabled, _ := strconv.ParseBool(getEnvOrDefault("CACHE_ENABLED", "false"))
+	cacheMaxBytes, _ := strconv.ParseInt(getEnvOrDefault("CACHE_MAX_BYTES", "10737418240"), 10, 64) // 10GB default
+	cachePort, _ := strconv.Atoi(getEnvOrDefault("CACHE_PORT", "9002"))
+	cacheInterval, _ := strconv.Atoi(getEnvOrDefault("CACHE_INTERVAL", "300"))
+	cacheMinPopularity, _ := strconv.Atoi(getEnvOrDefault("CACHE_MIN_POPULARITY", "5"))
+	cacheFetch, _ := strconv.Atoi(getEnvOrDefault("CACHE_FETCH_PER_INTERVAL", "2"))
+	downloadPath := getEnvOrDefault("DEFAULT_DOWNLOAD_PATH", "./downloads")
+	searchFuzziness, _ := strconv.Atoi(getEnvOrDefault("SEARCH_FUZZINESS", "2"))
+	maxSavedSearches, _ := strconv.Atoi(getEnvOrDefault("MAX_SAVED_SEARCHES", "20"))
+	maxPreviewSize, _ := strconv.ParseInt(getEnvOrDefault("MAX_PREVIEW_SIZE", "1048576"), 10, 64) // 1MB default
 	jwtExp, _ := strconv.Atoi(getEnvOrDefault("JWT_EXPIRATION", "24"))
+	federationSync, _ := strconv.Atoi(getEnvOrDefault("FEDERATION_SYNC_INTERVAL", "30"))
+	federationHops, _ := strconv.Atoi(getEnvOrDefault("FEDERATION_HOP_LIMIT", "2"))
+	serverHost := getEnvOrDefault("SERVER_HOST", "localhost")
 
 	config := &Config{
 		ServerPort:  port,
-		ServerHost:  getEnvOrDefault("SERVER_HOST", "localhost"),
+		ServerHost:  serverHost,
 		Environment: getEnvOrDefault("ENVIRONMENT", "development"),
 		AllowedOrigins: []string{
 			"http://localhost:3000",
@@ -70,12 +160,25 @@ func NewConfig(logger *zap.Logger) (*Config, error) {
 		DBName:     getEnvOrDefault("DB_NAME", "p2p"),
 		DBSSLMode:  getEnvOrDefault("DB_SSLMODE", "disable"),
 
-		MaxPeers:            maxPeers,
-		MaxSuperPeers:       maxSuperPeers,
-		HeartbeatInterval:   heartbeat,
-		ConnectionTimeout:   timeout,
-		MaxFileSize:         maxFileSize,
-		DefaultDownloadPath: getEnvOrDefault("DEFAULT_DOWNLOAD_PATH", "./downloads"),
+		MaxPeers:         maxPeers,
+		MaxSuperPeers:    maxSuperPeers,
+		JoinQueueSize:    joinQueueSize,
+		JoinQueueTimeout: joinQueueTimeout,
+
+		SuperPeerElection:         superPeerElection,
+		ElectionInterval:          electionInterval,
+		SuperPeerMinUptime:        superPeerMinUptime,
+		SuperPeerMinBandwidth:     superPeerMinBandwidth,
+		SuperPeerRequireReachable: superPeerRequireReachable,
+
+		ReachabilityProbeEnabled:    probeEnabled,
+		ReachabilityProbeTimeout:    probeTimeout,
+		ReachabilityRecheckInterval: probeRecheck,
+		HeartbeatInterval:           heartbeat,
+		ConnectionTimeout:           timeout,
+		SessionGracePeriod:          sessionGrace,
+		MaxFileSize:                 maxFileSize,
+		DefaultDownloadPath:         downloadPath,
 		AllowedFileTypes: []string{
 			"image/*",
 			"video/*",
@@ -87,6 +190,47 @@ func NewConfig(logger *zap.Logger) (*Config, error) {
 			"application/x-7z-compressed",
 		},
 
+		SearchBackend:   getEnvOrDefault("SEARCH_BACKEND", "inverted"),
+		SearchFuzziness: searchFuzziness,
+
+		MaxSavedSearches: maxSavedSearches,
+
+		PreviewDir:     getEnvOrDefault("PREVIEW_DIR", filepath.Join(downloadPath, "previews")),
+		MaxPreviewSize: maxPreviewSize,
+		AllowedPreviewTypes: []string{
+			"image/jpeg",
+			"image/png",
+			"image/gif",
+			"image/webp",
+			"application/pdf",
+			"text/plain",
+		},
+
+		RelayEnabled:        relayEnabled,
+		RelayPort:           relayPort,
+		RelayPublicHost:     getEnvOrDefault("RELAY_PUBLIC_HOST", ""),
+		RelayBandwidthLimit: relayBandwidth,
+		RelaySessionTimeout: relayTimeout,
+
+		RendezvousEnabled: rendezvousEnabled,
+		RendezvousPort:    rendezvousPort,
+		PunchTimeout:      punchTimeout,
+
+		CacheEnabled:          cacheEnabled,
+		CacheDir:              getEnvOrDefault("CACHE_DIR", filepath.Join(downloadPath, "cache")),
+		CacheMaxBytes:         cacheMaxBytes,
+		CachePort:             cachePort,
+		CacheInterval:         cacheInterval,
+		CacheMinPopularity:    cacheMinPopularity,
+		CacheFetchPerInterval: cacheFetch,
+
+		ServerID:               getEnvOrDefault("SERVER_ID", fmt.Sprintf("%s:%d", serverHost, port)),
+		PublicURL:              getEnvOrDefault("SERVER_PUBLIC_URL", fmt.Sprintf("http://%s:%d", serverHost, port)),
+		FederationPeers:        getEnvList("FEDERATION_PEERS"),
+		FederationSecret:       getEnvOrDefault("FEDERATION_SECRET", ""),
+		FederationSyncInterval: federationSync,
+		FederationHopLimit:     federationHops,
+
 		JWTSecret:     getEnvOrDefault("JWT_SECRET", "your-secret-key"),
 		JWTExpiration: jwtExp,
 		Logger:        logger,
@@ -120,3 +264,14 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// Helper function to read a comma-separated list from an environment variable
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -17,6 +17,7 @@ type User struct {
 	Username     string    `gorm:"uniqueIndex;not null" json:"username"`
 	IsSuper      bool      `gorm:"default:false" json:"is_super"`
 	PasswordHash string    `gorm:"not null" json:"-"`
+	PublicKey    string    `gorm:"type:varchar(64)" json:"public_key,omitempty"`
 	LastSeen     time.Time `json:"last_seen"`
 	IPAddress    string    `json:"ip_address,omitempty"` // Consider if this should be in User table
 	CreatedAt    time.Time `json:"created_at"`
@@ -24,17 +25,20 @@ type User struct {
 }
 
 type File struct {
-	ID           string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
-	Name         string    `gorm:"not null" json:"name"`
-	Type         string    `json:"type"`
-	Size         int64     `json:"size"`
-	OwnerID      string    `gorm:"type:varchar(36);index" json:"owner_id"` // Added index for faster lookups
-	Path         string    `json:"path"`
-	Hash         string    `gorm:"index" json:"hash"`
-	PreviewURL   string    `json:"preview_url,omitempty"`
-	LastModified time.Time `json:"last_modified"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	Name          string    `gorm:"not null" json:"name"`
+	Type          string    `json:"type"`
+	Size          int64     `json:"size"`
+	OwnerID       string    `gorm:"type:varchar(36);index" json:"owner_id"` // Added index for faster lookups
+	Path          string    `json:"path"`
+	Hash          string    `gorm:"index" json:"hash"`
+	HashAlgorithm string    `gorm:"type:varchar(16)" json:"hash_algorithm,omitempty"` // Algorithm of the Merkle root, empty if none was announced
+	MerkleRoot    string    `gorm:"type:varchar(128)" json:"merkle_root,omitempty"`
+	ChunkSize     int64     `json:"chunk_size,omitempty"`
+	PreviewURL    string    `json:"preview_url,omitempty"`
+	LastModified  time.Time `json:"last_modified"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 type SharedSpace struct {
@@ -61,6 +65,88 @@ type SpaceFile struct {
 	AddedAt time.Time `json:"added_at"`
 }
 
+// PeerSession persists an online peer's connection details so that a super-peer
+// restart does not forget who was connected
+type PeerSession struct {
+	PeerID       string    `gorm:"primaryKey;type:varchar(36)" json:"peer_id"`
+	IPAddress    string    `json:"ip_address"`
+	ListenPort   int       `json:"listen_port"`
+	IsSuper      bool      `gorm:"default:false" json:"is_super"`
+	Capabilities string    `json:"capabilities"` // Comma-separated capability names
+	LastPing     time.Time `json:"last_ping"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// FileMerkleTree keeps the chunk hashes of a file so the super peer can serve
+// proofs against the file's Merkle root
+type FileMerkleTree struct {
+	FileID    string    `gorm:"primaryKey;type:varchar(36)" json:"file_id"`
+	Leaves    string    `gorm:"type:longtext" json:"leaves"` // Comma-separated hex chunk hashes
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PeerLibrary tracks the version of a peer's synced library so that deltas can
+// be checked against the state they were computed from
+type PeerLibrary struct {
+	PeerID    string    `gorm:"primaryKey;type:varchar(36)" json:"peer_id"`
+	Version   int64     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TransferReceipt records a download reported and signed by the downloading peer.
+// Confirmed receipts were countersigned by the uploader as well.
+type TransferReceipt struct {
+	ID           string    `gorm:"primaryKey;type:varchar(64)" json:"id"`
+	DownloaderID string    `gorm:"type:varchar(36);index" json:"downloader_id"`
+	UploaderID   string    `gorm:"type:varchar(36);index" json:"uploader_id"`
+	FileHash     string    `gorm:"type:varchar(128);index" json:"file_hash"`
+	Bytes        int64     `json:"bytes"`
+	DurationMs   int64     `json:"duration_ms"`
+	Confirmed    bool      `gorm:"default:false" json:"confirmed"`
+	CompletedAt  time.Time `json:"completed_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// FileStats aggregates the confirmed transfer receipts of one file hash
+type FileStats struct {
+	Hash             string    `gorm:"primaryKey;type:varchar(128)" json:"hash"`
+	Downloads        int64     `gorm:"index" json:"downloads"`
+	BytesTransferred int64     `json:"bytes_transferred"`
+	LastDownloadAt   time.Time `json:"last_download_at"`
+}
+
+// PeerStats aggregates the confirmed transfer receipts a peer took part in
+type PeerStats struct {
+	PeerID          string `gorm:"primaryKey;type:varchar(36)" json:"peer_id"`
+	BytesUploaded   int64  `json:"bytes_uploaded"`
+	BytesDownloaded int64  `json:"bytes_downloaded"`
+	Uploads         int64  `json:"uploads"`
+	Downloads       int64  `json:"downloads"`
+}
+
+// SavedSearch is a search a user is notified about when newly shared files match it
+type SavedSearch struct {
+	ID        string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	UserID    string    `gorm:"type:varchar(36);index;not null" json:"user_id"`
+	Name      string    `gorm:"not null" json:"name"`
+	Filters   string    `gorm:"type:text" json:"-"` // JSON-encoded query and filters
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Notification tells a user that a newly shared file matches one of their saved searches
+type Notification struct {
+	ID            string     `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	UserID        string     `gorm:"type:varchar(36);index;not null" json:"user_id"`
+	SavedSearchID string     `gorm:"type:varchar(36);index" json:"saved_search_id"`
+	FileID        string     `gorm:"type:varchar(36)" json:"file_id"`
+	FileName      string     `json:"file_name"`
+	OwnerID       string     `gorm:"type:varchar(36)" json:"owner_id"`
+	ReadAt        *time.Time `json:"read_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
 // Database represents the database connection and operations
 type Database struct {
 	db *gorm.DB
@@ -93,6 +179,14 @@ func NewDatabase(cfg *config.Config) (*Database, error) {
 		&SharedSpace{},
 		&SpaceMember{},
 		&SpaceFile{},
+		&PeerSession{},
+		&FileMerkleTree{},
+		&PeerLibrary{},
+		&TransferReceipt{},
+		&FileStats{},
+		&PeerStats{},
+		&SavedSearch{},
+		&Notification{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate MySQL database: %w", err)
 	}
@@ -0,0 +1,103 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DuplicateGroup is a piece of content shared more than once within a library,
+// usually under different names
+type DuplicateGroup struct {
+	Hash        string     `json:"hash"`
+	Size        int64      `json:"size"`
+	Names       []string   `json:"names"` // Distinct names the copies have
+	Files       []*db.File `json:"files"` // Oldest first
+	WastedBytes int64      `json:"wasted_bytes"`
+}
+
+// DuplicateReport lists the duplicated content in a peer's or a space's library
+type DuplicateReport struct {
+	Groups         []*DuplicateGroup `json:"groups"`          // Most wasted space first
+	DuplicateFiles int               `json:"duplicate_files"` // Copies beyond the first of each group
+	WastedBytes    int64             `json:"wasted_bytes"`    // Size of those copies
+}
+
+// PeerDuplicates reports the files a peer shares more than once
+func (s *Service) PeerDuplicates(ctx context.Context, peerID string) (*DuplicateReport, error) {
+	report, err := s.duplicates(ctx, func(q *gorm.DB) *gorm.DB {
+		return q.Where("files.owner_id = ?", peerID)
+	})
+	if err != nil {
+		s.logger.Error("Failed to find duplicate files of peer", zap.Error(err), zap.String("peerID", peerID))
+		return nil, err
+	}
+	return report, nil
+}
+
+// SpaceDuplicates reports the content that was added to a space more than once
+func (s *Service) SpaceDuplicates(ctx context.Context, spaceID string) (*DuplicateReport, error) {
+	report, err := s.duplicates(ctx, func(q *gorm.DB) *gorm.DB {
+		return q.Where("files.id IN (?)", s.db.GetDB().Model(&db.SpaceFile{}).Select("file_id").Where("space_id = ?", spaceID))
+	})
+	if err != nil {
+		s.logger.Error("Failed to find duplicate files in space", zap.Error(err), zap.String("spaceID", spaceID))
+		return nil, err
+	}
+	return report, nil
+}
+
+// duplicates groups the files selected by scope by content hash and reports the
+// groups with more than one file
+func (s *Service) duplicates(ctx context.Context, scope func(*gorm.DB) *gorm.DB) (*DuplicateReport, error) {
+	report := &DuplicateReport{Groups: []*DuplicateGroup{}}
+
+	var hashes []string
+	err := s.db.GetDB().WithContext(ctx).Model(&db.File{}).Scopes(scope).
+		Where("files.hash <> ''").
+		Group("files.hash").
+		Having("COUNT(*) > 1").
+		Pluck("files.hash", &hashes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicate files: %w", err)
+	}
+	if len(hashes) == 0 {
+		return report, nil
+	}
+
+	// Each hash falls in one batch, so the files of a group stay in creation order
+	files, err := FindIn[db.File](s.db.GetDB().WithContext(ctx).Model(&db.File{}).Scopes(scope).
+		Order("files.created_at"), "files.hash", hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load duplicate files: %w", err)
+	}
+
+	groups := make(map[string]*DuplicateGroup, len(hashes))
+	for _, file := range files {
+		group, ok := groups[file.Hash]
+		if !ok {
+			group = &DuplicateGroup{Hash: file.Hash, Size: file.Size}
+			groups[file.Hash] = group
+			report.Groups = append(report.Groups, group)
+		}
+		group.Files = append(group.Files, file)
+		if !slices.Contains(group.Names, file.Name) {
+			group.Names = append(group.Names, file.Name)
+		}
+	}
+	for _, group := range report.Groups {
+		copies := len(group.Files) - 1
+		group.WastedBytes = int64(copies) * group.Size
+		report.DuplicateFiles += copies
+		report.WastedBytes += group.WastedBytes
+	}
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		return report.Groups[i].WastedBytes > report.Groups[j].WastedBytes
+	})
+	return report, nil
+}
@@ -0,0 +1,253 @@
+package index
+
+import (
+	"sort"
+	"strings"
+)
+
+// fuzzyWeight discounts terms that only match a query term within the edit
+// tolerance; it is divided by the number of edits
+const fuzzyWeight = 0.4
+
+// fuzzyMatch is an indexed term close to a query term
+type fuzzyMatch struct {
+	term     string
+	distance int
+}
+
+// allowedEdits returns how many typos a query term may contain: none for very
+// short terms, where any edit yields a different word, one up to five letters
+// and the configured tolerance beyond that
+func (x *InvertedIndex) allowedEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 3:
+		return 0
+	case n < 6:
+		return min(1, x.maxEdits)
+	default:
+		return x.maxEdits
+	}
+}
+
+// fuzzyTerms returns the indexed terms within the edit tolerance of a query term,
+// closest first. Candidates are the terms sharing a trigram with the query term.
+// Must be called with the index read lock held.
+func (x *InvertedIndex) fuzzyTerms(queryTerm string) []fuzzyMatch {
+	maxEdits := x.allowedEdits(queryTerm)
+	if maxEdits <= 0 {
+		return nil
+	}
+
+	query := []rune(queryTerm)
+	seen := make(map[string]bool)
+	var matches []fuzzyMatch
+	for _, gram := range trigrams(queryTerm) {
+		for term := range x.trigrams[gram] {
+			if seen[term] {
+				continue
+			}
+			seen[term] = true
+			if distance := editDistance(query, []rune(term), maxEdits); distance <= maxEdits {
+				matches = append(matches, fuzzyMatch{term: term, distance: distance})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		// Among equally close terms the more common one is the likelier intent
+		if a, b := len(x.postings[matches[i].term]), len(x.postings[matches[j].term]); a != b {
+			return a > b
+		}
+		return matches[i].term < matches[j].term
+	})
+	return matches
+}
+
+// known reports whether a query term matches an indexed term exactly or as a
+// prefix. Must be called with the index read lock held.
+func (x *InvertedIndex) known(queryTerm string) bool {
+	if _, ok := x.postings[Stem(queryTerm)]; ok {
+		return true
+	}
+	if len(queryTerm) < minPrefixLength {
+		return false
+	}
+	i := sort.SearchStrings(x.sortedTerms, queryTerm)
+	return i < len(x.sortedTerms) && strings.HasPrefix(x.sortedTerms[i], queryTerm)
+}
+
+// Suggest returns the query with every term that matches nothing replaced by the
+// closest indexed term, or "" if all terms match or none can be corrected
+func (x *InvertedIndex) Suggest(query string) string {
+	if x.maxEdits <= 0 {
+		return ""
+	}
+	terms := Tokenize(query)
+
+	x.refreshTerms()
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	corrected := false
+	for i, term := range terms {
+		if x.known(term) {
+			continue
+		}
+		if matches := x.fuzzyTerms(Stem(term)); len(matches) > 0 {
+			terms[i] = matches[0].term
+			corrected = true
+		}
+	}
+	if !corrected {
+		return ""
+	}
+	return strings.Join(terms, " ")
+}
+
+// Complete returns the most common completions of the last term of a partially
+// typed query. Earlier terms narrow the completions to terms of files that
+// contain them too.
+func (x *InvertedIndex) Complete(prefix string, limit int) []Completion {
+	terms := Tokenize(prefix)
+	if len(terms) == 0 || limit <= 0 {
+		return nil
+	}
+	last := terms[len(terms)-1]
+	leading := strings.Join(terms[:len(terms)-1], " ")
+	if leading != "" {
+		leading += " "
+	}
+
+	x.refreshTerms()
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	// Files containing every earlier term; nil means all files
+	var within map[string]int
+	for _, term := range terms[:len(terms)-1] {
+		docs := x.postings[Stem(term)]
+		if within == nil {
+			within = make(map[string]int, len(docs))
+			for id := range docs {
+				within[id] = 0
+			}
+			continue
+		}
+		for id := range within {
+			if _, ok := docs[id]; !ok {
+				delete(within, id)
+			}
+		}
+	}
+	if within != nil && len(within) == 0 {
+		return nil
+	}
+
+	var completions []Completion
+	start := sort.SearchStrings(x.sortedTerms, last)
+	for _, term := range x.sortedTerms[start:] {
+		if !strings.HasPrefix(term, last) {
+			break
+		}
+		files := len(x.postings[term])
+		if within != nil {
+			files = 0
+			for id := range x.postings[term] {
+				if _, ok := within[id]; ok {
+					files++
+				}
+			}
+		}
+		if files > 0 {
+			completions = append(completions, Completion{Text: leading + term, Term: term, Files: files})
+		}
+	}
+	sort.Slice(completions, func(i, j int) bool {
+		if completions[i].Files != completions[j].Files {
+			return completions[i].Files > completions[j].Files
+		}
+		return completions[i].Term < completions[j].Term
+	})
+	if len(completions) > limit {
+		completions = completions[:limit]
+	}
+	return completions
+}
+
+// addTrigrams registers a new term for fuzzy lookups. Must be called with the
+// index lock held.
+func (x *InvertedIndex) addTrigrams(term string) {
+	for _, gram := range trigrams(term) {
+		terms, ok := x.trigrams[gram]
+		if !ok {
+			terms = make(map[string]bool)
+			x.trigrams[gram] = terms
+		}
+		terms[term] = true
+	}
+}
+
+// removeTrigrams drops a term that no file contains any more. Must be called with
+// the index lock held.
+func (x *InvertedIndex) removeTrigrams(term string) {
+	for _, gram := range trigrams(term) {
+		delete(x.trigrams[gram], term)
+		if len(x.trigrams[gram]) == 0 {
+			delete(x.trigrams, gram)
+		}
+	}
+}
+
+// trigrams returns the distinct three-letter sequences of a term, padded so that
+// its first and last letters form trigrams of their own
+func trigrams(term string) []string {
+	runes := []rune("$$" + term + "$")
+	grams := make([]string, 0, len(runes)-2)
+	seen := make(map[string]bool, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// editDistance returns the Damerau-Levenshtein distance between two terms (in its
+// optimal string alignment form: insertions, deletions, substitutions and swaps of
+// adjacent letters), or maxEdits+1 as soon as it is known to exceed maxEdits
+func editDistance(a, b []rune, maxEdits int) int {
+	if len(a)-len(b) > maxEdits || len(b)-len(a) > maxEdits {
+		return maxEdits + 1
+	}
+
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > maxEdits {
+			return maxEdits + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,197 @@
+package index
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/inventor7/p2p/internal/db"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		maxEdits int
+		want     int
+	}{
+		{a: "report", b: "report", maxEdits: 2, want: 0},
+		{a: "reprot", b: "report", maxEdits: 2, want: 1}, // Transposition
+		{a: "rpeort", b: "report", maxEdits: 2, want: 1},
+		{a: "rport", b: "report", maxEdits: 2, want: 1},
+		{a: "repoort", b: "report", maxEdits: 2, want: 1},
+		{a: "raport", b: "report", maxEdits: 2, want: 1},
+		{a: "erpotr", b: "report", maxEdits: 2, want: 2},
+		{a: "ca", b: "abc", maxEdits: 3, want: 3}, // No edits of a swapped pair
+		{a: "kitten", b: "sitting", maxEdits: 3, want: 3},
+		{a: "café", b: "cafe", maxEdits: 1, want: 1},
+		{a: "kitten", b: "sitting", maxEdits: 2, want: 3}, // Stops past the tolerance
+		{a: "a", b: "abcd", maxEdits: 1, want: 2},
+		{a: "", b: "ab", maxEdits: 2, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := editDistance([]rune(tt.a), []rune(tt.b), tt.maxEdits); got != tt.want {
+				t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.maxEdits, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedEdits(t *testing.T) {
+	tests := []struct {
+		term     string
+		maxEdits int
+		want     int
+	}{
+		{term: "ab", maxEdits: 2, want: 0},
+		{term: "abc", maxEdits: 2, want: 1},
+		{term: "abcde", maxEdits: 2, want: 1},
+		{term: "abcdef", maxEdits: 2, want: 2},
+		{term: "abcdef", maxEdits: 1, want: 1},
+		{term: "abc", maxEdits: 0, want: 0},
+		{term: "abcdef", maxEdits: 0, want: 0},
+		{term: "éèà", maxEdits: 2, want: 1}, // Letters, not bytes
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			if got := NewInvertedIndex(tt.maxEdits).allowedEdits(tt.term); got != tt.want {
+				t.Errorf("allowedEdits(%q) with %d = %d, want %d", tt.term, tt.maxEdits, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuzzyTerms(t *testing.T) {
+	x := testIndex(t, 2, map[string]string{
+		"1": "cart",
+		"2": "cart",
+		"3": "card",
+		"4": "carts and cards",
+		"5": "chart",
+	})
+	x.refreshTerms()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "carx", want: []string{"cart", "card"}}, // Equally close, the more common first
+		{query: "chrat", want: []string{"chart"}},       // Transposition
+		{query: "crat", want: []string{"cart"}},         // Four letters allow one edit
+		{query: "ca", want: nil},                        // Too short to correct
+		{query: "zzzz", want: nil},                      // Nothing shares a trigram
+		{query: "and", want: []string{"and"}},           // Exact terms are within the tolerance
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var got []string
+			for _, match := range x.fuzzyTerms(tt.query) {
+				got = append(got, match.term)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("fuzzyTerms(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	x := testIndex(t, 2, map[string]string{
+		"a": "Quarterly Reports.pdf",
+		"b": "holiday photos.jpg",
+	})
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "quartely reprots", want: "quarterly report"}, // Corrected to the indexed stem
+		{query: "Holday", want: "holiday"},
+		{query: "quarterly reprots", want: "quarterly report"},
+		{query: "quarterly reports", want: ""}, // Every term matches
+		{query: "quar", want: ""},              // Prefixes match
+		{query: "xyzzy", want: ""},             // Nothing close enough
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := x.Suggest(tt.query); got != tt.want {
+				t.Errorf("Suggest(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	if got := testIndex(t, 0, map[string]string{"a": "report"}).Suggest("reprot"); got != "" {
+		t.Errorf("Suggest without typo tolerance = %q, want none", got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	x := NewInvertedIndex(2)
+	x.Index(
+		&db.File{ID: "1", Name: "annual report.pdf"},
+		&db.File{ID: "2", Name: "annual review.pdf"},
+		&db.File{ID: "3", Name: "quarterly report.pdf"},
+		&db.File{ID: "4", Name: "reply.txt"},
+	)
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []Completion
+	}{
+		{
+			prefix: "re",
+			limit:  10,
+			want: []Completion{
+				{Text: "report", Term: "report", Files: 2},
+				{Text: "reply", Term: "reply", Files: 1},
+				{Text: "review", Term: "review", Files: 1},
+			},
+		},
+		{
+			prefix: "re",
+			limit:  1,
+			want:   []Completion{{Text: "report", Term: "report", Files: 2}},
+		},
+		{
+			prefix: "Annual RE",
+			limit:  10,
+			want: []Completion{
+				{Text: "annual report", Term: "report", Files: 1},
+				{Text: "annual review", Term: "review", Files: 1},
+			},
+		},
+		{
+			prefix: "quarterly annual re",
+			limit:  10,
+			want:   nil, // No file contains both earlier terms
+		},
+		{
+			// Earlier terms are stemmed before narrowing
+			prefix: "reports re",
+			limit:  10,
+			want:   []Completion{{Text: "reports report", Term: "report", Files: 2}},
+		},
+		{
+			prefix: "missing re",
+			limit:  10,
+			want:   nil,
+		},
+		{
+			prefix: "  ",
+			limit:  10,
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if got := x.Complete(tt.prefix, tt.limit); !slices.Equal(got, tt.want) {
+				t.Errorf("Complete(%q, %d) = %+v, want %+v", tt.prefix, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package index
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/inventor7/p2p/internal/db"
+)
+
+// BM25 parameters: term frequency saturation and document length normalisation
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// prefixWeight discounts terms that only start with a query term ("rep" for "report")
+// against exact matches
+const prefixWeight = 0.5
+
+// minPrefixLength is the shortest query term that is also matched as a prefix
+const minPrefixLength = 2
+
+// indexedDoc is a file as the inverted index sees it
+type indexedDoc struct {
+	length int      // Number of terms
+	terms  []string // Distinct terms, to find the postings to drop on removal
+}
+
+// InvertedIndex is an in-memory full-text index over file names and types. It
+// ranks matches with BM25 and matches query terms as prefixes of indexed terms
+// and, for terms that match nothing, within an edit distance of indexed terms.
+type InvertedIndex struct {
+	docs        map[string]*indexedDoc    // Keyed by file ID
+	postings    map[string]map[string]int // Term -> file ID -> occurrences
+	sortedTerms []string                  // For prefix lookups; rebuilt lazily
+	dirty       bool                      // Whether sortedTerms is out of date
+	totalLength int
+	mu          sync.RWMutex
+
+	// Fuzzy matching
+	trigrams map[string]map[string]bool // Trigram -> terms containing it
+	maxEdits int                        // Typos tolerated per query term, 0 to disable
+}
+
+// NewInvertedIndex creates an empty inverted index that tolerates up to maxEdits
+// typos in each query term
+func NewInvertedIndex(maxEdits int) *InvertedIndex {
+	return &InvertedIndex{
+		docs:     make(map[string]*indexedDoc),
+		postings: make(map[string]map[string]int),
+		trigrams: make(map[string]map[string]bool),
+		maxEdits: maxEdits,
+	}
+}
+
+// Len returns the number of indexed files
+func (x *InvertedIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// Index adds files to the index, replacing any earlier version of them
+func (x *InvertedIndex) Index(files ...*db.File) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, file := range files {
+		x.remove(file.ID)
+
+		terms := Analyze(file.Name + " " + file.Type)
+		doc := &indexedDoc{length: len(terms)}
+		for _, term := range terms {
+			docs, ok := x.postings[term]
+			if !ok {
+				docs = make(map[string]int)
+				x.postings[term] = docs
+				x.addTrigrams(term)
+				x.dirty = true
+			}
+			if docs[file.ID] == 0 {
+				doc.terms = append(doc.terms, term)
+			}
+			docs[file.ID]++
+		}
+		x.docs[file.ID] = doc
+		x.totalLength += doc.length
+	}
+}
+
+// Remove drops files from the index
+func (x *InvertedIndex) Remove(fileIDs ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, id := range fileIDs {
+		x.remove(id)
+	}
+}
+
+// remove drops one file. Must be called with the index lock held.
+func (x *InvertedIndex) remove(fileID string) {
+	doc, ok := x.docs[fileID]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(x.postings[term], fileID)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+			x.removeTrigrams(term)
+			x.dirty = true
+		}
+	}
+	x.totalLength -= doc.length
+	delete(x.docs, fileID)
+}
+
+// Search returns the files containing every query term, exactly, as a prefix or
+// misspelled, ordered by their summed BM25 scores
+func (x *InvertedIndex) Search(ctx context.Context, query string) ([]SearchHit, error) {
+	queryTerms := Tokenize(query)
+	if len(queryTerms) == 0 {
+		return nil, nil
+	}
+
+	x.refreshTerms()
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if len(x.docs) == 0 {
+		return nil, nil
+	}
+	avgLength := float64(x.totalLength) / float64(len(x.docs))
+
+	var scores map[string]float64
+	for _, queryTerm := range queryTerms {
+		matches := x.matchTerm(queryTerm, avgLength)
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		// Every query term has to match
+		for id, score := range scores {
+			if match, ok := matches[id]; ok {
+				scores[id] = score + match
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, SearchHit{FileID: id, Relevance: math.Round(score*1000) / 1000})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Relevance != hits[j].Relevance {
+			return hits[i].Relevance > hits[j].Relevance
+		}
+		return hits[i].FileID < hits[j].FileID
+	})
+	return hits, nil
+}
+
+// matchTerm scores the files matching one query term. A file matching several
+// indexed terms (the exact stem and longer words starting with the term) keeps
+// its best score. Terms that match nothing that way are looked up fuzzily.
+// Must be called with the index read lock held.
+func (x *InvertedIndex) matchTerm(queryTerm string, avgLength float64) map[string]float64 {
+	matches := make(map[string]float64)
+	add := func(term string, weight float64) {
+		docs := x.postings[term]
+		idf := math.Log(1 + (float64(len(x.docs))-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+		for id, tf := range docs {
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(x.docs[id].length)/avgLength)
+			score := weight * idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+			if score > matches[id] {
+				matches[id] = score
+			}
+		}
+	}
+
+	stem := Stem(queryTerm)
+	add(stem, 1)
+	if len(queryTerm) >= minPrefixLength {
+		start := sort.SearchStrings(x.sortedTerms, queryTerm)
+		for _, term := range x.sortedTerms[start:] {
+			if !strings.HasPrefix(term, queryTerm) {
+				break
+			}
+			if term != stem {
+				add(term, prefixWeight)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		for _, match := range x.fuzzyTerms(stem) {
+			add(match.term, fuzzyWeight/float64(match.distance))
+		}
+	}
+	return matches
+}
+
+// refreshTerms rebuilds the sorted term list after terms were added or removed
+func (x *InvertedIndex) refreshTerms() {
+	x.mu.RLock()
+	dirty := x.dirty
+	x.mu.RUnlock()
+	if !dirty {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.dirty {
+		return
+	}
+	x.sortedTerms = x.sortedTerms[:0]
+	for term := range x.postings {
+		x.sortedTerms = append(x.sortedTerms, term)
+	}
+	sort.Strings(x.sortedTerms)
+	x.dirty = false
+}
@@ -0,0 +1,148 @@
+package index
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/inventor7/p2p/internal/db"
+)
+
+// testIndex indexes files by ID and name, without a type
+func testIndex(t *testing.T, maxEdits int, names map[string]string) *InvertedIndex {
+	t.Helper()
+
+	x := NewInvertedIndex(maxEdits)
+	for id, name := range names {
+		x.Index(&db.File{ID: id, Name: name})
+	}
+	return x
+}
+
+func searchIDs(t *testing.T, x *InvertedIndex, query string) []string {
+	t.Helper()
+
+	hits, err := x.Search(context.Background(), query)
+	if err != nil {
+		t.Fatalf("search for %q failed: %v", query, err)
+	}
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.FileID
+	}
+	return ids
+}
+
+func TestInvertedIndexSearch(t *testing.T) {
+	x := testIndex(t, 2, map[string]string{
+		"annual":    "annual report.pdf",
+		"draft":     "report draft.pdf",
+		"long":      "report of the annual general meeting minutes.pdf",
+		"twice":     "report report.pdf",
+		"rep":       "rep notes.txt",
+		"replies":   "replies.txt",
+		"unrelated": "holiday photo.jpg",
+	})
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "every term has to match",
+			query: "report draft",
+			want:  []string{"draft"},
+		},
+		{
+			name:  "repeated and shorter matches rank first",
+			query: "report",
+			want:  []string{"twice", "annual", "draft", "long"},
+		},
+		{
+			name:  "plural query matches the singular",
+			query: "reports annual",
+			want:  []string{"annual", "long"},
+		},
+		{
+			name:  "exact match ranks before prefix matches",
+			query: "rep",
+			want:  []string{"rep", "replies", "twice", "annual", "draft", "long"},
+		},
+		{
+			name:  "stemmed terms match their plural",
+			query: "reply",
+			want:  []string{"replies"},
+		},
+		{
+			name:  "single letters are not matched as prefixes",
+			query: "r",
+			want:  []string{},
+		},
+		{
+			name:  "misspelled term",
+			query: "reprot draft",
+			want:  []string{"draft"},
+		},
+		{
+			name:  "no terms",
+			query: "...",
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchIDs(t, x, tt.query); !slices.Equal(got, tt.want) {
+				t.Errorf("search for %q = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvertedIndexTiesOrderedByID(t *testing.T) {
+	x := testIndex(t, 0, map[string]string{
+		"c": "notes",
+		"a": "notes",
+		"b": "notes",
+	})
+
+	hits, err := x.Search(context.Background(), "notes")
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	for i, want := range []string{"a", "b", "c"} {
+		if hits[i].FileID != want {
+			t.Errorf("hit %d = %s, want %s", i, hits[i].FileID, want)
+		}
+		if hits[i].Relevance != hits[0].Relevance {
+			t.Errorf("hit %d scored %v, want %v", i, hits[i].Relevance, hits[0].Relevance)
+		}
+	}
+}
+
+func TestInvertedIndexReindexAndRemove(t *testing.T) {
+	x := testIndex(t, 0, map[string]string{
+		"a": "budget.xlsx",
+		"b": "budget notes.txt",
+	})
+
+	x.Index(&db.File{ID: "a", Name: "forecast.xlsx"})
+	if got := searchIDs(t, x, "budget"); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("after reindexing, budget matched %q", got)
+	}
+	if got := searchIDs(t, x, "forecast"); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("after reindexing, forecast matched %q", got)
+	}
+
+	x.Remove("b")
+	if x.Len() != 1 {
+		t.Errorf("index holds %d files after removal, want 1", x.Len())
+	}
+	if got := searchIDs(t, x, "budget"); len(got) != 0 {
+		t.Errorf("removed file still matched: %q", got)
+	}
+	if got := searchIDs(t, x, "not"); len(got) != 0 {
+		t.Errorf("terms of the removed file still matched as prefixes: %q", got)
+	}
+}
@@ -0,0 +1,281 @@
+package index
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+	"gorm.io/gorm"
+)
+
+// Sort orders for searches
+const (
+	SortRelevance  = "relevance"
+	SortSize       = "size"
+	SortDate       = "date" // Last modified
+	SortPopularity = "popularity"
+)
+
+// Page sizes for searches
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var (
+	// ErrInvalidCursor is returned for cursors that are malformed or belong to a different sort order
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInvalidSearchOptions is returned for unknown sort orders or contradictory filters
+	ErrInvalidSearchOptions = errors.New("invalid search options")
+)
+
+// Categories maps the file categories clients can filter by to MIME type patterns
+var Categories = map[string][]string{
+	"image":    {"image/*"},
+	"video":    {"video/*"},
+	"audio":    {"audio/*"},
+	"text":     {"text/*"},
+	"document": {"application/pdf", "application/msword", "application/vnd.openxmlformats-officedocument.*", "application/vnd.oasis.opendocument.*", "text/*"},
+	"archive":  {"application/zip", "application/x-rar-compressed", "application/x-7z-compressed", "application/gzip", "application/x-tar"},
+}
+
+// SearchOptions narrows, orders and pages a file search. Zero values leave a
+// filter out. Options are forwarded to federated servers, which apply the filters
+// and leave paging to the server the search started on.
+type SearchOptions struct {
+	Query          string    `json:"query,omitempty"`
+	Types          []string  `json:"types,omitempty"` // MIME types; "image/*" matches a whole category
+	MinSize        int64     `json:"min_size,omitempty"`
+	MaxSize        int64     `json:"max_size,omitempty"`
+	ModifiedAfter  time.Time `json:"modified_after,omitempty"`
+	ModifiedBefore time.Time `json:"modified_before,omitempty"`
+	OwnerID        string    `json:"owner_id,omitempty"`
+	SpaceID        string    `json:"space_id,omitempty"`
+	OnlineOnly     bool      `json:"online_only,omitempty"` // Only files whose owner is connected
+	Sort           string    `json:"sort,omitempty"`
+	Ascending      bool      `json:"ascending,omitempty"`
+	Cursor         string    `json:"-"`
+	Limit          int       `json:"-"`
+
+	// Online reports whether a peer is connected; needed for OnlineOnly by services
+	// that do not track presence themselves
+	Online func(peerID string) bool `json:"-"`
+}
+
+// Normalize fills in defaults and rejects options that cannot be served
+func (o *SearchOptions) Normalize() error {
+	o.Query = strings.TrimSpace(o.Query)
+	o.Sort = strings.ToLower(o.Sort)
+	switch o.Sort {
+	case "":
+		// Without a query every file is equally relevant
+		o.Sort = SortRelevance
+		if o.Query == "" {
+			o.Sort = SortDate
+		}
+	case SortRelevance, SortSize, SortDate, SortPopularity:
+	default:
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidSearchOptions, o.Sort)
+	}
+
+	switch {
+	case o.Limit <= 0:
+		o.Limit = DefaultPageSize
+	case o.Limit > MaxPageSize:
+		o.Limit = MaxPageSize
+	}
+	if o.MinSize < 0 || o.MaxSize < 0 || (o.MaxSize > 0 && o.MinSize > o.MaxSize) {
+		return fmt.Errorf("%w: size range", ErrInvalidSearchOptions)
+	}
+	if !o.ModifiedAfter.IsZero() && !o.ModifiedBefore.IsZero() && o.ModifiedAfter.After(o.ModifiedBefore) {
+		return fmt.Errorf("%w: modified date range", ErrInvalidSearchOptions)
+	}
+	return nil
+}
+
+// Scope applies the filters that can be evaluated in the database to a query on
+// the files table
+func (o *SearchOptions) Scope(q *gorm.DB) *gorm.DB {
+	if len(o.Types) > 0 {
+		var conditions []string
+		var args []interface{}
+		for _, fileType := range o.Types {
+			fileType = strings.ToLower(strings.TrimSpace(fileType))
+			if prefix, ok := strings.CutSuffix(fileType, "*"); ok {
+				conditions = append(conditions, "files.type LIKE ?")
+				args = append(args, prefix+"%")
+			} else {
+				conditions = append(conditions, "files.type = ?")
+				args = append(args, fileType)
+			}
+		}
+		q = q.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	if o.MinSize > 0 {
+		q = q.Where("files.size >= ?", o.MinSize)
+	}
+	if o.MaxSize > 0 {
+		q = q.Where("files.size <= ?", o.MaxSize)
+	}
+	if !o.ModifiedAfter.IsZero() {
+		q = q.Where("files.last_modified >= ?", o.ModifiedAfter)
+	}
+	if !o.ModifiedBefore.IsZero() {
+		q = q.Where("files.last_modified <= ?", o.ModifiedBefore)
+	}
+	if o.OwnerID != "" {
+		q = q.Where("files.owner_id = ?", o.OwnerID)
+	}
+	if o.SpaceID != "" {
+		q = q.Where("files.id IN (?)", q.Session(&gorm.Session{NewDB: true}).Model(&db.SpaceFile{}).Select("file_id").Where("space_id = ?", o.SpaceID))
+	}
+	return q
+}
+
+// Matches reports whether a single file satisfies the query and filters, the way
+// Scope and the search backend would select it. Space membership is not checked.
+func (o *SearchOptions) Matches(file *db.File) bool {
+	if len(o.Types) > 0 {
+		fileType := strings.ToLower(file.Type)
+		matched := false
+		for _, pattern := range o.Types {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+				matched = strings.HasPrefix(fileType, prefix)
+			} else {
+				matched = fileType == pattern
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	switch {
+	case o.MinSize > 0 && file.Size < o.MinSize,
+		o.MaxSize > 0 && file.Size > o.MaxSize,
+		!o.ModifiedAfter.IsZero() && file.LastModified.Before(o.ModifiedAfter),
+		!o.ModifiedBefore.IsZero() && file.LastModified.After(o.ModifiedBefore),
+		o.OwnerID != "" && file.OwnerID != o.OwnerID:
+		return false
+	}
+	return matchesQuery(o.Query, file)
+}
+
+// matchesQuery reports whether every query term occurs in the file's name or
+// type, exactly or as a prefix, as the inverted index matches them
+func matchesQuery(query string, file *db.File) bool {
+	fileTerms := Analyze(file.Name + " " + file.Type)
+	for _, queryTerm := range Tokenize(query) {
+		stem := Stem(queryTerm)
+		found := false
+		for _, term := range fileTerms {
+			if term == stem || (len(queryTerm) >= minPrefixLength && strings.HasPrefix(term, queryTerm)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SortKey positions one result in a sorted listing. Primary is the value of the
+// sort order, Secondary breaks ties and ID makes the order total.
+type SortKey struct {
+	Primary   float64 `json:"p"`
+	Secondary float64 `json:"s,omitempty"`
+	ID        string  `json:"id"`
+}
+
+// cursor is the decoded form of a page cursor: the key of the last result returned
+type cursor struct {
+	Sort      string  `json:"sort"`
+	Ascending bool    `json:"asc,omitempty"`
+	After     SortKey `json:"after"`
+}
+
+// less orders keys by the requested direction; IDs always ascend
+func (o *SearchOptions) less(a, b SortKey) bool {
+	if a.Primary != b.Primary {
+		return (a.Primary < b.Primary) == o.Ascending
+	}
+	if a.Secondary != b.Secondary {
+		return (a.Secondary < b.Secondary) == o.Ascending
+	}
+	return a.ID < b.ID
+}
+
+// Paginate sorts results by their keys and returns the positions of the results
+// on the requested page, in order, together with the cursor of the next page
+func (o *SearchOptions) Paginate(keys []SortKey) ([]int, string, error) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return o.less(keys[order[i]], keys[order[j]])
+	})
+
+	start := 0
+	if o.Cursor != "" {
+		after, err := o.decodeCursor()
+		if err != nil {
+			return nil, "", err
+		}
+		// The page starts at the first result ordered after the last one returned
+		start = sort.Search(len(order), func(i int) bool {
+			return o.less(after, keys[order[i]])
+		})
+	}
+
+	end := start + o.Limit
+	if end >= len(order) {
+		return order[start:], "", nil
+	}
+	page := order[start:end]
+	return page, o.encodeCursor(keys[page[len(page)-1]]), nil
+}
+
+func (o *SearchOptions) encodeCursor(after SortKey) string {
+	payload, _ := json.Marshal(cursor{Sort: o.Sort, Ascending: o.Ascending, After: after})
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func (o *SearchOptions) decodeCursor() (SortKey, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(o.Cursor)
+	if err != nil {
+		return SortKey{}, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return SortKey{}, ErrInvalidCursor
+	}
+	if c.Sort != o.Sort || c.Ascending != o.Ascending {
+		return SortKey{}, fmt.Errorf("%w: cursor belongs to a different sort order", ErrInvalidCursor)
+	}
+	return c.After, nil
+}
+
+// Key builds the sort key of a file for the options' sort order
+func (o *SearchOptions) Key(id string, file *db.File, relevance float64, downloads int64) SortKey {
+	switch o.Sort {
+	case SortSize:
+		return SortKey{Primary: float64(file.Size), ID: id}
+	case SortDate:
+		return SortKey{Primary: float64(file.LastModified.UnixMilli()), ID: id}
+	case SortPopularity:
+		return SortKey{Primary: float64(downloads), Secondary: relevance, ID: id}
+	default:
+		return SortKey{Primary: relevance, Secondary: float64(downloads), ID: id}
+	}
+}
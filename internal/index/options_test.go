@@ -0,0 +1,219 @@
+package index
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+)
+
+func TestSearchOptionsNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      SearchOptions
+		wantSort  string
+		wantLimit int
+		wantErr   bool
+	}{
+		{
+			name:      "query sorts by relevance",
+			opts:      SearchOptions{Query: " report "},
+			wantSort:  SortRelevance,
+			wantLimit: DefaultPageSize,
+		},
+		{
+			name:      "no query sorts by date",
+			opts:      SearchOptions{Query: "  "},
+			wantSort:  SortDate,
+			wantLimit: DefaultPageSize,
+		},
+		{
+			name:      "sort is case insensitive",
+			opts:      SearchOptions{Sort: "Size", Limit: 10},
+			wantSort:  SortSize,
+			wantLimit: 10,
+		},
+		{
+			name:      "limit is capped",
+			opts:      SearchOptions{Limit: MaxPageSize + 1},
+			wantSort:  SortDate,
+			wantLimit: MaxPageSize,
+		},
+		{
+			name:    "unknown sort",
+			opts:    SearchOptions{Sort: "name"},
+			wantErr: true,
+		},
+		{
+			name:    "inverted size range",
+			opts:    SearchOptions{MinSize: 10, MaxSize: 5},
+			wantErr: true,
+		},
+		{
+			name:    "inverted date range",
+			opts:    SearchOptions{ModifiedAfter: time.Now(), ModifiedBefore: time.Now().Add(-time.Hour)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Normalize()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSearchOptions) {
+					t.Fatalf("expected ErrInvalidSearchOptions, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.opts.Sort != tt.wantSort || tt.opts.Limit != tt.wantLimit {
+				t.Errorf("got sort %q and limit %d, want %q and %d", tt.opts.Sort, tt.opts.Limit, tt.wantSort, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestSearchOptionsMatches(t *testing.T) {
+	file := &db.File{
+		Name:         "Quarterly Reports 2024.pdf",
+		Type:         "application/pdf",
+		Size:         2048,
+		OwnerID:      "peer-1",
+		LastModified: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want bool
+	}{
+		{name: "no filters", want: true},
+		{name: "stemmed query", opts: SearchOptions{Query: "quarterly report"}, want: true},
+		{name: "prefix query", opts: SearchOptions{Query: "quart"}, want: true},
+		{name: "missing term", opts: SearchOptions{Query: "quarterly summary"}},
+		{name: "type pattern", opts: SearchOptions{Types: []string{"image/*", "application/*"}}, want: true},
+		{name: "other type", opts: SearchOptions{Types: []string{"text/plain"}}},
+		{name: "size range", opts: SearchOptions{MinSize: 1024, MaxSize: 4096}, want: true},
+		{name: "too small", opts: SearchOptions{MinSize: 4096}},
+		{name: "modified before", opts: SearchOptions{ModifiedBefore: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{name: "other owner", opts: SearchOptions{OwnerID: "peer-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Matches(file); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testKeys are results with tied primary and secondary values, in no particular order
+var testKeys = []SortKey{
+	{Primary: 2, Secondary: 1, ID: "d"},
+	{Primary: 3, ID: "a"},
+	{Primary: 2, Secondary: 5, ID: "c"},
+	{Primary: 1, ID: "e"},
+	{Primary: 2, Secondary: 1, ID: "b"},
+}
+
+func keyIDs(keys []SortKey, positions []int) []string {
+	ids := make([]string, len(positions))
+	for i, pos := range positions {
+		ids[i] = keys[pos].ID
+	}
+	return ids
+}
+
+func TestPaginateOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		ascending bool
+		want      []string
+	}{
+		{name: "descending", want: []string{"a", "c", "b", "d", "e"}},
+		{name: "ascending", ascending: true, want: []string{"e", "b", "d", "c", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &SearchOptions{Sort: SortRelevance, Ascending: tt.ascending, Limit: 2}
+
+			var got []string
+			for page := 0; ; page++ {
+				if page > len(testKeys) {
+					t.Fatal("pagination did not end")
+				}
+				positions, next, err := opts.Paginate(testKeys)
+				if err != nil {
+					t.Fatalf("page %d failed: %v", page, err)
+				}
+				if len(positions) > opts.Limit {
+					t.Errorf("page %d has %d results, limit is %d", page, len(positions), opts.Limit)
+				}
+				got = append(got, keyIDs(testKeys, positions)...)
+				if next == "" {
+					break
+				}
+				opts.Cursor = next
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("pages returned %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaginateCursorSurvivesNewResults(t *testing.T) {
+	opts := &SearchOptions{Sort: SortSize, Limit: 2}
+	keys := []SortKey{{Primary: 30, ID: "a"}, {Primary: 20, ID: "b"}, {Primary: 10, ID: "c"}}
+
+	positions, next, err := opts.Paginate(keys)
+	if err != nil {
+		t.Fatalf("first page failed: %v", err)
+	}
+	if got := keyIDs(keys, positions); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("first page = %q", got)
+	}
+
+	// Results added before and after the cursor since the first page
+	keys = append(keys, SortKey{Primary: 40, ID: "new-first"}, SortKey{Primary: 20, ID: "c-tied"})
+	opts.Cursor = next
+	positions, next, err = opts.Paginate(keys)
+	if err != nil {
+		t.Fatalf("second page failed: %v", err)
+	}
+	if got := keyIDs(keys, positions); !slices.Equal(got, []string{"c-tied", "c"}) || next != "" {
+		t.Errorf("second page = %q with cursor %q, want [c-tied c] and no cursor", got, next)
+	}
+}
+
+func TestPaginateRejectsForeignCursors(t *testing.T) {
+	opts := &SearchOptions{Sort: SortSize, Limit: 2}
+	_, next, err := opts.Paginate(testKeys)
+	if err != nil || next == "" {
+		t.Fatalf("first page failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+	}{
+		{name: "other sort", opts: SearchOptions{Sort: SortDate, Limit: 2, Cursor: next}},
+		{name: "other direction", opts: SearchOptions{Sort: SortSize, Ascending: true, Limit: 2, Cursor: next}},
+		{name: "not base64", opts: SearchOptions{Sort: SortSize, Limit: 2, Cursor: "%%%"}},
+		{name: "not json", opts: SearchOptions{Sort: SortSize, Limit: 2, Cursor: "bm90IGpzb24"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.opts.Paginate(testKeys); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("expected ErrInvalidCursor, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrSavedSearchLimit is returned when a user already has as many saved searches as allowed
+	ErrSavedSearchLimit = errors.New("saved search limit reached")
+	// ErrSavedSearchNotFound is returned for saved searches that do not exist or belong to another user
+	ErrSavedSearchNotFound = errors.New("saved search not found")
+)
+
+// SavedSearch is a saved search with its decoded query and filters
+type SavedSearch struct {
+	db.SavedSearch
+	Options *SearchOptions `json:"options"`
+}
+
+// SaveSearch stores a search the user wants to be notified about. Space filters
+// are rejected since newly shared files are not in any space yet.
+func (s *Service) SaveSearch(ctx context.Context, userID, name string, opts *SearchOptions) (*SavedSearch, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+	if opts.SpaceID != "" {
+		return nil, fmt.Errorf("%w: saved searches cannot filter by space", ErrInvalidSearchOptions)
+	}
+	if opts.Query == "" && len(opts.Types) == 0 && opts.OwnerID == "" {
+		return nil, fmt.Errorf("%w: a query, type or owner is required", ErrInvalidSearchOptions)
+	}
+	filters, err := json.Marshal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode search options: %w", err)
+	}
+
+	saved := &SavedSearch{
+		SavedSearch: db.SavedSearch{
+			ID:      uuid.New().String(),
+			UserID:  userID,
+			Name:    name,
+			Filters: string(filters),
+		},
+		Options: opts,
+	}
+	err = s.db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Locking the user serialises concurrent saves, so the count below stays
+		// accurate until the new search is inserted
+		var user db.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", userID).First(&user).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+		var count int64
+		if err := tx.Model(&db.SavedSearch{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count saved searches: %w", err)
+		}
+		if s.cfg.MaxSavedSearches > 0 && count >= int64(s.cfg.MaxSavedSearches) {
+			return fmt.Errorf("%w: at most %d saved searches per user", ErrSavedSearchLimit, s.cfg.MaxSavedSearches)
+		}
+		if err := tx.Create(&saved.SavedSearch).Error; err != nil {
+			return fmt.Errorf("failed to save search: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Saved search", zap.String("userID", userID), zap.String("savedSearchID", saved.ID), zap.String("query", opts.Query))
+	return saved, nil
+}
+
+// ListSavedSearches returns the user's saved searches, newest first
+func (s *Service) ListSavedSearches(ctx context.Context, userID string) ([]*SavedSearch, error) {
+	var records []*db.SavedSearch
+	if err := s.db.GetDB().WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+
+	searches := make([]*SavedSearch, 0, len(records))
+	for _, record := range records {
+		saved, err := decodeSavedSearch(record)
+		if err != nil {
+			s.logger.Warn("Skipping unreadable saved search", zap.Error(err), zap.String("savedSearchID", record.ID))
+			continue
+		}
+		searches = append(searches, saved)
+	}
+	return searches, nil
+}
+
+// DeleteSavedSearch removes one of the user's saved searches and its notifications
+func (s *Service) DeleteSavedSearch(ctx context.Context, userID, id string) error {
+	return s.db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&db.SavedSearch{})
+		if res.Error != nil {
+			return fmt.Errorf("failed to delete saved search: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrSavedSearchNotFound
+		}
+		if err := tx.Where("saved_search_id = ?", id).Delete(&db.Notification{}).Error; err != nil {
+			return fmt.Errorf("failed to delete notifications: %w", err)
+		}
+		return nil
+	})
+}
+
+// NotifyMatches checks newly shared files against every saved search and
+// notifies the users whose searches they match. The saved searches are read and
+// decoded once for the whole batch of files. Owners are not notified about their
+// own files.
+func (s *Service) NotifyMatches(ctx context.Context, files []*db.File) {
+	if len(files) == 0 {
+		return
+	}
+
+	var notifications []*db.Notification
+	var batch []*db.SavedSearch
+	err := s.db.GetDB().WithContext(ctx).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, record := range batch {
+			saved, err := decodeSavedSearch(record)
+			if err != nil {
+				s.logger.Warn("Skipping unreadable saved search", zap.Error(err), zap.String("savedSearchID", record.ID))
+				continue
+			}
+			for _, file := range files {
+				if record.UserID == file.OwnerID || !saved.Options.Matches(file) {
+					continue
+				}
+				notifications = append(notifications, &db.Notification{
+					ID:            uuid.New().String(),
+					UserID:        record.UserID,
+					SavedSearchID: record.ID,
+					FileID:        file.ID,
+					FileName:      file.Name,
+					OwnerID:       file.OwnerID,
+				})
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		s.logger.Error("Failed to match saved searches", zap.Error(err), zap.Int("files", len(files)))
+		return
+	}
+	if len(notifications) == 0 {
+		return
+	}
+
+	if err := s.db.GetDB().WithContext(ctx).CreateInBatches(&notifications, 500).Error; err != nil {
+		s.logger.Error("Failed to store notifications", zap.Error(err), zap.Int("files", len(files)))
+		return
+	}
+	s.logger.Info("Notified saved search matches", zap.Int("files", len(files)), zap.Int("notifications", len(notifications)))
+}
+
+// ListNotifications returns the user's notifications, newest first
+func (s *Service) ListNotifications(ctx context.Context, userID string, unreadOnly bool, limit int) ([]*db.Notification, error) {
+	switch {
+	case limit <= 0:
+		limit = DefaultPageSize
+	case limit > MaxPageSize:
+		limit = MaxPageSize
+	}
+
+	q := s.db.GetDB().WithContext(ctx).Where("user_id = ?", userID)
+	if unreadOnly {
+		q = q.Where("read_at IS NULL")
+	}
+	notifications := []*db.Notification{}
+	if err := q.Order("created_at DESC").Limit(limit).Find(&notifications).Error; err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks the given notifications of the user as read, or all
+// of them if no IDs are given, and returns how many were unread
+func (s *Service) MarkNotificationsRead(ctx context.Context, userID string, ids []string) (int64, error) {
+	q := s.db.GetDB().WithContext(ctx).Model(&db.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if len(ids) > 0 {
+		q = q.Where("id IN ?", ids)
+	}
+	res := q.Update("read_at", time.Now())
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// decodeSavedSearch decodes the stored query and filters of a saved search
+func decodeSavedSearch(record *db.SavedSearch) (*SavedSearch, error) {
+	opts := &SearchOptions{}
+	if err := json.Unmarshal([]byte(record.Filters), opts); err != nil {
+		return nil, fmt.Errorf("failed to decode search options: %w", err)
+	}
+	return &SavedSearch{SavedSearch: *record, Options: opts}, nil
+}
@@ -0,0 +1,172 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/inventor7/p2p/internal/config"
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Search backends selectable through SEARCH_BACKEND
+const (
+	BackendInverted = "inverted" // In-memory inverted index with BM25 ranking
+	BackendSQL      = "sql"      // LIKE queries against the files table
+)
+
+// SearchHit is a file matched by a search backend
+type SearchHit struct {
+	FileID    string
+	Relevance float64 // Higher is more relevant; only comparable within one search
+}
+
+// SearchResult is a file matched by a search with its relevance to the query
+type SearchResult struct {
+	db.File
+	Relevance float64 `json:"relevance"`
+	Downloads int64   `json:"downloads"`
+}
+
+// SearchPage is one page of search results
+type SearchPage struct {
+	Files      []*SearchResult `json:"files"`
+	Total      int             `json:"total"`                 // Matches across all pages
+	NextCursor string          `json:"next_cursor,omitempty"` // Empty on the last page
+	Suggestion string          `json:"suggestion,omitempty"`  // Corrected query when terms were misspelled
+}
+
+// Numbers of completions returned for a partially typed query
+const (
+	DefaultCompletions = 10
+	MaxCompletions     = 50
+)
+
+// Completion is a way to finish a partially typed query
+type Completion struct {
+	Text  string `json:"text"`  // The query with its last term completed
+	Term  string `json:"term"`  // The completed term
+	Files int    `json:"files"` // Files the completed query matches
+}
+
+// SearchBackend finds shared files matching a free-text query. Backends that keep
+// their own index are told about every file that is shared, changed or withdrawn.
+type SearchBackend interface {
+	// Index adds files to the backend, replacing any earlier version of them
+	Index(files ...*db.File)
+	// Remove drops files from the backend
+	Remove(fileIDs ...string)
+	// Search returns the files matching every term of the query, most relevant first
+	Search(ctx context.Context, query string) ([]SearchHit, error)
+}
+
+// Suggester is implemented by search backends that can correct misspelled queries
+// and complete partially typed ones
+type Suggester interface {
+	// Suggest returns the query with unknown terms replaced by the closest known
+	// ones, or "" if there is nothing to correct
+	Suggest(query string) string
+	// Complete returns the most common completions of the last term of prefix
+	Complete(prefix string, limit int) []Completion
+}
+
+// SuggestQuery returns the backend's correction of a query, or "" if the backend
+// has none or cannot correct queries
+func SuggestQuery(backend SearchBackend, query string) string {
+	if suggester, ok := backend.(Suggester); ok && query != "" {
+		return suggester.Suggest(query)
+	}
+	return ""
+}
+
+// NewSearchBackend creates the search backend selected in the configuration. The
+// inverted index is filled with the files already in the database.
+func NewSearchBackend(cfg *config.Config, database *db.Database, logger *zap.Logger) (SearchBackend, error) {
+	switch strings.ToLower(cfg.SearchBackend) {
+	case "", BackendInverted:
+		index := NewInvertedIndex(cfg.SearchFuzziness)
+		var batch []*db.File
+		err := database.GetDB().Select("id", "name", "type").FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+			index.Index(batch...)
+			return nil
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to build search index: %w", err)
+		}
+		logger.Info("Search index built", zap.Int("files", index.Len()), zap.Int("fuzziness", cfg.SearchFuzziness))
+		return index, nil
+	case BackendSQL:
+		return &sqlBackend{db: database}, nil
+	default:
+		return nil, fmt.Errorf("unknown search backend %q", cfg.SearchBackend)
+	}
+}
+
+// sqlBackend matches every query term with LIKE against file names and types. It
+// needs no index of its own but cannot rank its matches, tolerate typos or
+// suggest corrections.
+type sqlBackend struct {
+	db *db.Database
+}
+
+func (b *sqlBackend) Index(files ...*db.File) {}
+
+func (b *sqlBackend) Remove(fileIDs ...string) {}
+
+func (b *sqlBackend) Search(ctx context.Context, query string) ([]SearchHit, error) {
+	terms := Tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// For MySQL, LIKE is case-insensitive with the default collations
+	q := b.db.GetDB().WithContext(ctx).Model(&db.File{})
+	for _, term := range terms {
+		pattern := "%" + term + "%"
+		q = q.Where("(name LIKE ? OR type LIKE ?)", pattern, pattern)
+	}
+	var ids []string
+	if err := q.Order("created_at DESC").Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to search files: %w", err)
+	}
+
+	hits := make([]SearchHit, len(ids))
+	for i, id := range ids {
+		hits[i] = SearchHit{FileID: id, Relevance: 1}
+	}
+	return hits, nil
+}
+
+// MaxQueryValues bounds the values bound into a single IN filter, well below
+// MySQL's limit of 65,535 placeholders per statement
+const MaxQueryValues = 1000
+
+// FindIn runs q once per batch of values for "column IN ?" and returns every
+// matching row, so that filters with many values stay within the database's
+// placeholder limit
+func FindIn[T any](q *gorm.DB, column string, values []string) ([]*T, error) {
+	q = q.Session(&gorm.Session{})
+	var rows []*T
+	for start := 0; start < len(values); start += MaxQueryValues {
+		end := min(start+MaxQueryValues, len(values))
+		var batch []*T
+		if err := q.Where(column+" IN ?", values[start:end]).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		rows = append(rows, batch...)
+	}
+	return rows, nil
+}
+
+// hitIDs returns the file IDs of search hits and their relevance by file ID
+func hitIDs(hits []SearchHit) ([]string, map[string]float64) {
+	ids := make([]string, len(hits))
+	relevance := make(map[string]float64, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.FileID
+		relevance[hit.FileID] = hit.Relevance
+	}
+	return ids, relevance
+}
@@ -16,11 +16,12 @@ import (
 type Service struct {
 	cfg    *config.Config
 	db     *db.Database
+	search SearchBackend
 	logger *zap.Logger
 }
 
 // NewService creates a new index service instance
-func NewService(cfg *config.Config, database *db.Database, logger *zap.Logger) *Service {
+func NewService(cfg *config.Config, database *db.Database, search SearchBackend, logger *zap.Logger) *Service {
 	// Robustness: Check for nil dependencies
 	if cfg == nil {
 		standardLog.Fatal("index.NewService: config cannot be nil")
@@ -36,10 +37,14 @@ func NewService(cfg *config.Config, database *db.Database, logger *zap.Logger) *
 	if logger == nil {
 		standardLog.Fatal("index.NewService: logger instance cannot be nil")
 	}
+	if search == nil {
+		logger.Fatal("index.NewService: search backend cannot be nil")
+	}
 
 	return &Service{
 		cfg:    cfg,
 		db:     database,
+		search: search,
 		logger: logger,
 	}
 }
@@ -261,8 +266,13 @@ func (s *Service) RemoveFromSpace(ctx context.Context, spaceID string, itemID st
 	return nil
 }
 
-func (s *Service) SearchFiles(ctx context.Context, userID string, query string) ([]*db.File, error) {
-	var files []*db.File
+// SearchFiles searches the files in the spaces the user is a member of, filtered,
+// ordered and paged according to opts
+func (s *Service) SearchFiles(ctx context.Context, userID string, opts *SearchOptions) (*SearchPage, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+	page := &SearchPage{Files: []*SearchResult{}, Suggestion: SuggestQuery(s.search, opts.Query)}
 
 	// Get all spaces the user is a member of
 	var spaceIDs []string
@@ -277,27 +287,106 @@ func (s *Service) SearchFiles(ctx context.Context, userID string, query string)
 
 	if len(spaceIDs) == 0 {
 		s.logger.Debug("User is not a member of any spaces, search will yield no results.", zap.String("userID", userID))
-		return files, nil // Return empty slice, not an error
-	}
-
-	// Search for files in these spaces
-	searchTerm := "%" + query + "%"
-	// For MySQL, LIKE is often case-insensitive. If specific case-insensitivity is required:
-	// .Where("space_files.space_id IN ? AND (LOWER(files.name) LIKE LOWER(?) OR LOWER(files.type) LIKE LOWER(?))",
-	// spaceIDs, searchTerm, searchTerm).
-	err = s.db.GetDB().Model(&db.File{}).
-		Joins("JOIN space_files ON space_files.file_id = files.id").
-		Where("space_files.space_id IN ?", spaceIDs).
-		Where("files.name LIKE ? OR files.type LIKE ?", searchTerm, searchTerm). // <--- CHANGED ILIKE to LIKE
-		Find(&files).Error
+		return page, nil // Return empty page, not an error
+	}
 
-	if err != nil {
-		s.logger.Error("Failed to search files in user's spaces", zap.Error(err), zap.String("userID", userID), zap.String("query", query))
+	q := s.db.GetDB().Model(&db.File{}).
+		Scopes(opts.Scope).
+		Where("files.id IN (?)", s.db.GetDB().Model(&db.SpaceFile{}).Select("file_id").Where("space_id IN ?", spaceIDs))
+
+	var relevance map[string]float64
+	var files []*db.File
+	if opts.Query != "" {
+		hits, err := s.search.Search(ctx, opts.Query)
+		if err != nil {
+			s.logger.Error("Failed to search files", zap.Error(err), zap.String("query", opts.Query))
+			return nil, fmt.Errorf("failed to search files: %w", err)
+		}
+		if len(hits) == 0 {
+			return page, nil
+		}
+		var ids []string
+		ids, relevance = hitIDs(hits)
+		files, err = FindIn[db.File](q, "files.id", ids)
+		if err != nil {
+			s.logger.Error("Failed to search files in user's spaces", zap.Error(err), zap.String("userID", userID), zap.String("query", opts.Query))
+			return nil, fmt.Errorf("failed to search files: %w", err)
+		}
+	} else if err := q.Find(&files).Error; err != nil {
+		s.logger.Error("Failed to search files in user's spaces", zap.Error(err), zap.String("userID", userID), zap.String("query", opts.Query))
 		return nil, fmt.Errorf("failed to search files: %w", err)
 	}
+	if opts.OnlineOnly && opts.Online != nil {
+		online := files[:0]
+		for _, file := range files {
+			if opts.Online(file.OwnerID) {
+				online = append(online, file)
+			}
+		}
+		files = online
+	}
 
-	s.logger.Info("Searched files for user", zap.String("userID", userID), zap.String("query", query), zap.Int("count", len(files)))
-	return files, nil
+	downloads, err := s.downloadCounts(files)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]SortKey, len(files))
+	for i, file := range files {
+		keys[i] = opts.Key(file.ID, file, relevance[file.ID], downloads[file.Hash])
+	}
+	positions, next, err := opts.Paginate(keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range positions {
+		page.Files = append(page.Files, &SearchResult{File: *files[i], Relevance: relevance[files[i].ID], Downloads: downloads[files[i].Hash]})
+	}
+	page.Total = len(files)
+	page.NextCursor = next
+
+	s.logger.Info("Searched files for user", zap.String("userID", userID), zap.String("query", opts.Query), zap.Int("total", page.Total), zap.Int("count", len(page.Files)))
+	return page, nil
+}
+
+// Autocomplete returns the most common completions of a partially typed query
+// across all shared files. Backends that cannot complete queries return none.
+func (s *Service) Autocomplete(ctx context.Context, prefix string, limit int) []Completion {
+	suggester, ok := s.search.(Suggester)
+	if !ok {
+		return []Completion{}
+	}
+	switch {
+	case limit <= 0:
+		limit = DefaultCompletions
+	case limit > MaxCompletions:
+		limit = MaxCompletions
+	}
+	completions := suggester.Complete(prefix, limit)
+	if completions == nil {
+		completions = []Completion{}
+	}
+	return completions
+}
+
+// downloadCounts returns the number of recorded downloads of each file's content, by hash
+func (s *Service) downloadCounts(files []*db.File) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(files) == 0 {
+		return counts, nil
+	}
+	hashes := make([]string, 0, len(files))
+	for _, file := range files {
+		hashes = append(hashes, file.Hash)
+	}
+
+	stats, err := FindIn[db.FileStats](s.db.GetDB(), "hash", hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load download counts: %w", err)
+	}
+	for _, st := range stats {
+		counts[st.Hash] = st.Downloads
+	}
+	return counts, nil
 }
 
 func (s *Service) ListSharedSpaces(ctx context.Context) ([]db.SharedSpace, error) {
@@ -0,0 +1,81 @@
+package index
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize splits text into lowercase terms. Besides separators such as spaces,
+// underscores and dots it splits camelCase words and letter/digit boundaries, so
+// "report_final", "FinalReport2024.pdf" and "final report" share their terms.
+func Tokenize(text string) []string {
+	var tokens []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+
+	runes := []rune(text)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if len(current) > 0 {
+			prev := runes[i-1]
+			switch {
+			case unicode.IsDigit(prev) != unicode.IsDigit(r):
+				flush()
+			case unicode.IsLower(prev) && unicode.IsUpper(r):
+				flush()
+			case unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+				// The last capital of an acronym starts the next word, e.g. "PDFViewer"
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+	return tokens
+}
+
+// Stem reduces a lowercase term to its singular form, so "reports" matches
+// "report". Other inflections ("shared", "sharing") are left to prefix matching,
+// since stripping them without a dictionary mangles too many words.
+func Stem(term string) string {
+	if len(term) <= 3 || !isAlpha(term) {
+		return term
+	}
+
+	switch {
+	case strings.HasSuffix(term, "sses"):
+		return term[:len(term)-2]
+	case strings.HasSuffix(term, "ies") && len(term) > 4:
+		return term[:len(term)-3] + "y"
+	case strings.HasSuffix(term, "s") && !strings.HasSuffix(term, "ss") &&
+		!strings.HasSuffix(term, "us") && !strings.HasSuffix(term, "is"):
+		return term[:len(term)-1]
+	}
+	return term
+}
+
+// Analyze tokenizes and stems text into the terms stored in the index
+func Analyze(text string) []string {
+	tokens := Tokenize(text)
+	for i, token := range tokens {
+		tokens[i] = Stem(token)
+	}
+	return tokens
+}
+
+func isAlpha(term string) bool {
+	for _, r := range term {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
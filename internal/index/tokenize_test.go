@@ -0,0 +1,64 @@
+package index
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "final report", want: []string{"final", "report"}},
+		{text: "report_final", want: []string{"report", "final"}},
+		{text: "FinalReport2024.pdf", want: []string{"final", "report", "2024", "pdf"}},
+		{text: "PDFViewer", want: []string{"pdf", "viewer"}},
+		{text: "IMG_0042.JPG", want: []string{"img", "0042", "jpg"}},
+		{text: "v2beta", want: []string{"v", "2", "beta"}},
+		{text: "café-Menu", want: []string{"café", "menu"}},
+		{text: "  --  ", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := Tokenize(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		term string
+		want string
+	}{
+		{term: "reports", want: "report"},
+		{term: "files", want: "file"},
+		{term: "classes", want: "class"},
+		{term: "libraries", want: "library"},
+		{term: "ties", want: "tie"}, // Too short for the "ies" rule
+		{term: "glass", want: "glass"},
+		{term: "status", want: "status"},
+		{term: "analysis", want: "analysis"},
+		{term: "bus", want: "bus"},
+		{term: "mp3s", want: "mp3s"},
+		{term: "shared", want: "shared"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			if got := Stem(tt.term); got != tt.want {
+				t.Errorf("Stem(%q) = %q, want %q", tt.term, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	want := []string{"quarterly", "report", "pdf"}
+	if got := Analyze("Quarterly_Reports.PDF"); !slices.Equal(got, want) {
+		t.Errorf("Analyze = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrNetworkFull is returned when this server has no room left for a joining peer
+var ErrNetworkFull = errors.New("super peer is at capacity")
+
+// Redirect points a rejected peer to another place it can join
+type Redirect struct {
+	Kind       string `json:"kind"` // "server" for a federated super-peer server, "super_peer" for a super peer on this network
+	ServerID   string `json:"server_id,omitempty"`
+	URL        string `json:"url,omitempty"`
+	PeerID     string `json:"peer_id,omitempty"`
+	IPAddress  string `json:"ip_address,omitempty"`
+	ListenPort int    `json:"listen_port,omitempty"`
+	FreeSlots  int    `json:"free_slots,omitempty"` // Omitted when the capacity is not known
+}
+
+// AdmissionError is returned when a join is rejected for lack of capacity. It
+// carries the alternatives the peer can try instead.
+type AdmissionError struct {
+	Redirects []Redirect
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("%s (%d alternatives)", ErrNetworkFull, len(e.Redirects))
+}
+
+func (e *AdmissionError) Unwrap() error {
+	return ErrNetworkFull
+}
+
+// admissionState tracks joins holding a reservation and joins queued for capacity.
+// It is guarded by the service lock.
+type admissionState struct {
+	pendingPeers int
+	pendingSuper int
+	waiting      int
+	slotFreed    chan struct{} // Closed and replaced whenever a slot frees up
+}
+
+// FreeSlots returns how many more regular peers a federated server can accept
+func (n *FederationNode) FreeSlots() int {
+	connected := 0
+	for _, peer := range n.Peers {
+		if !peer.IsSuper {
+			connected++
+		}
+	}
+	return n.MaxPeers - connected
+}
+
+// hasCapacity reports whether another peer of the given kind can be admitted,
+// counting joins that already hold a reservation. A limit of zero disables the check.
+// Must be called with the service lock held.
+func (s *Service) hasCapacity(isSuper bool) bool {
+	if isSuper {
+		return s.cfg.MaxSuperPeers <= 0 || len(s.superPeers)+s.admission.pendingSuper < s.cfg.MaxSuperPeers
+	}
+	return s.cfg.MaxPeers <= 0 || len(s.peers)+s.admission.pendingPeers < s.cfg.MaxPeers
+}
+
+// reserveSlot adjusts the number of in-flight joins of the given kind.
+// Must be called with the service lock held.
+func (s *Service) reserveSlot(isSuper bool, delta int) {
+	if isSuper {
+		s.admission.pendingSuper += delta
+	} else {
+		s.admission.pendingPeers += delta
+	}
+}
+
+// isConnected reports whether a peer currently holds a connection
+func (s *Service) isConnected(peerID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, online := s.peers[peerID]
+	if !online {
+		_, online = s.superPeers[peerID]
+	}
+	return online
+}
+
+// IsOnline reports whether a peer is currently connected to this server
+func (s *Service) IsOnline(peerID string) bool {
+	return s.isConnected(peerID)
+}
+
+// acquireSlot reserves room for a joining peer. When the server is full the join
+// waits in a bounded queue for up to JoinQueueTimeout before it is rejected with
+// an AdmissionError.
+func (s *Service) acquireSlot(ctx context.Context, isSuper bool) error {
+	timeout := time.Duration(s.cfg.JoinQueueTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	queued := false
+
+	s.mu.Lock()
+	for {
+		if s.hasCapacity(isSuper) {
+			if queued {
+				s.admission.waiting--
+			}
+			s.reserveSlot(isSuper, 1)
+			s.mu.Unlock()
+			return nil
+		}
+
+		if !queued {
+			if timeout <= 0 || s.admission.waiting >= s.cfg.JoinQueueSize {
+				s.mu.Unlock()
+				return s.admissionError(isSuper)
+			}
+			s.admission.waiting++
+			queued = true
+		}
+		freed := s.admission.slotFreed
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-freed:
+			timer.Stop()
+		case <-timer.C:
+			s.mu.Lock()
+			s.admission.waiting--
+			s.mu.Unlock()
+			return s.admissionError(isSuper)
+		case <-ctx.Done():
+			timer.Stop()
+			s.mu.Lock()
+			s.admission.waiting--
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+
+		s.mu.Lock()
+	}
+}
+
+// releaseSlot gives back a reservation taken by acquireSlot. Joins that failed
+// wake the queue since their slot is free again.
+func (s *Service) releaseSlot(isSuper bool, joined bool) {
+	s.mu.Lock()
+	s.reserveSlot(isSuper, -1)
+	if !joined {
+		s.notifySlotFreed()
+	}
+	s.mu.Unlock()
+}
+
+// notifySlotFreed wakes every join waiting for capacity.
+// Must be called with the service lock held.
+func (s *Service) notifySlotFreed() {
+	close(s.admission.slotFreed)
+	s.admission.slotFreed = make(chan struct{})
+}
+
+// admissionError builds the rejection for a full server, listing federated
+// servers with free capacity first and then the super peers known locally
+func (s *Service) admissionError(isSuper bool) error {
+	var servers []Redirect
+	for _, node := range s.FederationNodes() {
+		if free := node.FreeSlots(); free > 0 {
+			servers = append(servers, Redirect{
+				Kind:      "server",
+				ServerID:  node.ServerID,
+				URL:       node.URL,
+				FreeSlots: free,
+			})
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].FreeSlots > servers[j].FreeSlots
+	})
+
+	redirects := append(make([]Redirect, 0, len(servers)), servers...)
+	if !isSuper {
+		s.mu.RLock()
+		for _, conn := range s.superPeers {
+			if conn.IsActive {
+				redirects = append(redirects, Redirect{
+					Kind:       "super_peer",
+					PeerID:     conn.User.ID,
+					IPAddress:  conn.IPAddress,
+					ListenPort: conn.ListenPort,
+				})
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	return &AdmissionError{Redirects: redirects}
+}
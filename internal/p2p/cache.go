@@ -0,0 +1,329 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+	"github.com/inventor7/p2p/internal/transfer"
+	"go.uber.org/zap"
+)
+
+// Demand weights feeding the cache's popularity counters
+const (
+	demandSearch   = 1 // The file showed up in a search
+	demandSwarm    = 3 // A client looked up sources, i.e. is about to download it
+	demandDownload = 5 // A peer reported a completed download
+)
+
+// cacheEntry is a file held in the super peer's local store
+type cacheEntry struct {
+	hash       string
+	path       string
+	size       int64
+	lastAccess time.Time
+}
+
+// contentCache lets a super peer seed popular files itself so they stay available
+// when their owners go offline. It is guarded by its own lock, which is never held
+// while taking the service lock.
+type contentCache struct {
+	dir        string
+	server     *transfer.Server
+	downloader *transfer.SwarmDownloader
+
+	entries    map[string]*cacheEntry // Keyed by file hash
+	popularity map[string]int         // Decaying demand per file hash
+	usedBytes  int64
+	mu         sync.Mutex
+}
+
+// startCache restores the files cached before a restart and starts serving and
+// filling the cache
+func (s *Service) startCache() error {
+	dir := s.cfg.CacheDir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Nothing larger than the whole cache is ever downloaded into it
+	downloader := transfer.NewDownloader(&http.Client{}, s.logger)
+	downloader.MaxSize = s.cfg.CacheMaxBytes
+
+	s.cache = &contentCache{
+		dir:        dir,
+		server:     transfer.NewServer(transfer.DefaultChunkSize, s.logger),
+		downloader: transfer.NewSwarmDownloader(downloader, s.logger),
+		entries:    make(map[string]*cacheEntry),
+		popularity: make(map[string]int),
+	}
+	s.restoreCache()
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.CachePort))
+	if err != nil {
+		return fmt.Errorf("failed to start cache server: %w", err)
+	}
+	go func() {
+		if err := http.Serve(listener, s.cacheHandler()); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Error("Cache server stopped", zap.Error(err))
+		}
+	}()
+
+	s.logger.Info("Content cache enabled",
+		zap.String("dir", dir),
+		zap.Int64("max_bytes", s.cfg.CacheMaxBytes),
+		zap.String("address", listener.Addr().String()))
+
+	go s.runCache()
+	return nil
+}
+
+// restoreCache picks up the files left in the cache directory by a previous run
+func (s *Service) restoreCache() {
+	entries, err := os.ReadDir(s.cache.dir)
+	if err != nil {
+		s.logger.Error("Failed to read cache directory", zap.Error(err))
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		path := filepath.Join(s.cache.dir, entry.Name())
+		manifest, err := s.cache.server.AddFile(path)
+		if err != nil || manifest.FileHash != entry.Name() {
+			// Not a file we cached, or it got corrupted on disk
+			s.logger.Warn("Dropping unusable cache file", zap.String("path", path))
+			s.cache.server.RemoveFile(entry.Name())
+			os.Remove(path)
+			continue
+		}
+		s.cache.entries[manifest.FileHash] = &cacheEntry{
+			hash:       manifest.FileHash,
+			path:       path,
+			size:       manifest.Size,
+			lastAccess: time.Now(),
+		}
+		s.cache.usedBytes += manifest.Size
+	}
+}
+
+// cacheHandler serves cached files and counts each request as an access for LRU eviction
+func (s *Service) cacheHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/files/"), "/"); len(parts) > 0 {
+			s.touchCache(parts[0])
+		}
+		s.cache.server.ServeHTTP(w, r)
+	})
+}
+
+// recordDemand bumps the popularity of files that are searched for or downloaded
+func (s *Service) recordDemand(weight int, hashes ...string) {
+	if s.cache == nil {
+		return
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	for _, hash := range hashes {
+		if hash != "" {
+			s.cache.popularity[hash] += weight
+		}
+	}
+}
+
+// touchCache marks a cached file as recently used and reports whether it is cached
+func (s *Service) touchCache(hash string) bool {
+	if s.cache == nil {
+		return false
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	entry, ok := s.cache.entries[hash]
+	if ok {
+		entry.lastAccess = time.Now()
+	}
+	return ok
+}
+
+// isCached reports whether a file is cached without counting it as an access
+func (s *Service) isCached(hash string) bool {
+	if s.cache == nil {
+		return false
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	_, ok := s.cache.entries[hash]
+	return ok
+}
+
+// cacheEndpoint is the address other peers download cached files from
+func (s *Service) cacheEndpoint() (string, int) {
+	return s.publicHost(), s.cfg.CachePort
+}
+
+// runCache periodically pulls the most popular files into the cache
+func (s *Service) runCache() {
+	ticker := time.NewTicker(time.Duration(s.cfg.CacheInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.fillCache(context.Background())
+	}
+}
+
+// fillCache downloads the most popular uncached files that meet the popularity
+// threshold, then halves every counter so that old demand fades out
+func (s *Service) fillCache(ctx context.Context) {
+	type candidate struct {
+		hash  string
+		score int
+	}
+
+	s.cache.mu.Lock()
+	var candidates []candidate
+	for hash, score := range s.cache.popularity {
+		if _, cached := s.cache.entries[hash]; !cached && score >= s.cfg.CacheMinPopularity {
+			candidates = append(candidates, candidate{hash: hash, score: score})
+		}
+	}
+	for hash := range s.cache.popularity {
+		s.cache.popularity[hash] /= 2
+		if s.cache.popularity[hash] == 0 {
+			delete(s.cache.popularity, hash)
+		}
+	}
+	s.cache.mu.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if len(candidates) > s.cfg.CacheFetchPerInterval {
+		candidates = candidates[:s.cfg.CacheFetchPerInterval]
+	}
+
+	for _, c := range candidates {
+		if err := s.cacheFile(ctx, c.hash); err != nil {
+			s.logger.Warn("Failed to cache popular file", zap.Error(err), zap.String("hash", c.hash))
+		}
+	}
+}
+
+// cacheFile downloads a file from its online owners into the cache, evicting the
+// least recently used files to make room. The room needed is taken from the
+// verified manifest rather than the size the owners announced.
+func (s *Service) cacheFile(ctx context.Context, hash string) error {
+	swarm, err := s.swarmSources(hash)
+	if err != nil {
+		return err
+	}
+	if len(swarm.Sources) == 0 {
+		return fmt.Errorf("no online source")
+	}
+
+	sources := make([]transfer.Source, 0, len(swarm.Sources))
+	for _, source := range swarm.Sources {
+		sources = append(sources, transfer.Source{
+			PeerID:  source.PeerID,
+			BaseURL: "http://" + net.JoinHostPort(source.IPAddress, strconv.Itoa(source.ListenPort)),
+		})
+	}
+
+	manifest, err := s.cache.downloader.FetchManifest(ctx, sources, hash)
+	if err != nil {
+		return err
+	}
+	if manifest.Size > s.cfg.CacheMaxBytes {
+		return fmt.Errorf("file of %d bytes exceeds the cache size", manifest.Size)
+	}
+	s.evictCache(manifest.Size)
+
+	path := filepath.Join(s.cache.dir, hash)
+	if err := s.cache.downloader.DownloadManifest(ctx, sources, manifest, path); err != nil {
+		return err
+	}
+	if _, err := s.cache.server.AddFile(path); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	s.cache.mu.Lock()
+	s.cache.entries[hash] = &cacheEntry{hash: hash, path: path, size: manifest.Size, lastAccess: time.Now()}
+	s.cache.usedBytes += manifest.Size
+	s.cache.mu.Unlock()
+
+	s.logger.Info("Cached popular file", zap.String("hash", hash), zap.Int64("size", manifest.Size))
+	return nil
+}
+
+// evictCache removes least recently used files until size more bytes fit
+func (s *Service) evictCache(size int64) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	if s.cache.usedBytes+size <= s.cfg.CacheMaxBytes {
+		return
+	}
+
+	entries := make([]*cacheEntry, 0, len(s.cache.entries))
+	for _, entry := range s.cache.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastAccess.Before(entries[j].lastAccess)
+	})
+
+	for _, entry := range entries {
+		if s.cache.usedBytes+size <= s.cfg.CacheMaxBytes {
+			break
+		}
+		s.cache.server.RemoveFile(entry.hash)
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			s.logger.Error("Failed to remove evicted cache file", zap.Error(err), zap.String("path", entry.path))
+		}
+		delete(s.cache.entries, entry.hash)
+		s.cache.usedBytes -= entry.size
+		s.logger.Debug("Evicted cached file", zap.String("hash", entry.hash))
+	}
+}
+
+// cachedResults returns search results served from the cache for matched files
+// whose owners are offline, one per distinct hash
+func (s *Service) cachedResults(files []*db.File, online map[string]bool) []*FileSearchResult {
+	if s.cache == nil {
+		return nil
+	}
+	host, port := s.cacheEndpoint()
+
+	var results []*FileSearchResult
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if online[file.Hash] || seen[file.Hash] || !s.touchCache(file.Hash) {
+			continue
+		}
+		seen[file.Hash] = true
+		results = append(results, &FileSearchResult{
+			File:             *file,
+			PeerIPAddress:    host,
+			PeerListenPort:   port,
+			PeerConnectivity: ConnectivityPublic,
+			Cached:           true,
+			SourceScore:      cacheSourceScore(),
+		})
+	}
+	return results
+}
@@ -0,0 +1,166 @@
+package p2p
+
+import (
+	"sort"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+)
+
+// Peer roles reported to clients
+const (
+	RolePeer      = "peer"
+	RoleSuperPeer = "super_peer"
+)
+
+// role returns the role a connection currently plays in the network
+func (p *PeerConnection) role() string {
+	if p.User.IsSuper {
+		return RoleSuperPeer
+	}
+	return RolePeer
+}
+
+// uptime returns how long the peer has been online. Peers may report a longer
+// uptime than this server has observed, e.g. after reconnecting.
+func (p *PeerConnection) uptime() time.Duration {
+	observed := time.Since(p.ConnectedAt)
+	if p.ReportedUptime > observed {
+		return p.ReportedUptime
+	}
+	return observed
+}
+
+// PeerRole returns the current role of a connected peer
+func (s *Service) PeerRole(peerID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.superPeers[peerID]; ok {
+		return RoleSuperPeer, nil
+	}
+	if _, ok := s.peers[peerID]; ok {
+		return RolePeer, nil
+	}
+	return "", ErrPeerNotFound
+}
+
+// qualifiesAsSuper applies the promotion policy to a peer.
+// Must be called with the service lock held.
+func (s *Service) qualifiesAsSuper(conn *PeerConnection) bool {
+	if s.cfg.SuperPeerRequireReachable && !conn.reachable() {
+		return false
+	}
+	return conn.UploadBandwidth >= s.cfg.SuperPeerMinBandwidth &&
+		conn.uptime() >= time.Duration(s.cfg.SuperPeerMinUptime)*time.Second
+}
+
+// shouldDemote reports whether a super peer fell far enough below the policy to
+// lose its role. Bandwidth gets some slack so that a single slow measurement does
+// not flip a peer back and forth. Must be called with the service lock held.
+func (s *Service) shouldDemote(conn *PeerConnection) bool {
+	if s.cfg.SuperPeerRequireReachable && !conn.reachable() {
+		return true
+	}
+	return conn.UploadBandwidth < s.cfg.SuperPeerMinBandwidth/2
+}
+
+// superPeerScore ranks candidates for promotion; higher is better.
+// Must be called with the service lock held.
+func (s *Service) superPeerScore(conn *PeerConnection) float64 {
+	score := conn.uptime().Hours()
+	if s.cfg.SuperPeerMinBandwidth > 0 {
+		score += float64(conn.UploadBandwidth) / float64(s.cfg.SuperPeerMinBandwidth)
+	}
+	return score
+}
+
+// runElection periodically re-evaluates which peers act as super peers
+func (s *Service) runElection() {
+	ticker := time.NewTicker(time.Duration(s.cfg.ElectionInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.elect()
+	}
+}
+
+// elect promotes the best candidates until MaxSuperPeers is reached and demotes
+// super peers that no longer meet the policy. A demoted peer needs a free regular
+// slot like any joining peer; until one is free it keeps its role. Promotions run
+// first, so that the slots they free can take demoted peers.
+func (s *Service) elect() {
+	s.mu.Lock()
+	var changed []*PeerConnection
+
+	var candidates []*PeerConnection
+	for _, conn := range s.peers {
+		if conn.IsActive && conn.SuperCandidate && s.qualifiesAsSuper(conn) {
+			candidates = append(candidates, conn)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return s.superPeerScore(candidates[i]) > s.superPeerScore(candidates[j])
+	})
+	for _, conn := range candidates {
+		if !s.hasCapacity(true) {
+			break
+		}
+		delete(s.peers, conn.User.ID)
+		conn.User.IsSuper = true
+		s.superPeers[conn.User.ID] = conn
+		changed = append(changed, conn)
+	}
+
+	deferred := 0
+	for id, conn := range s.superPeers {
+		if !s.shouldDemote(conn) {
+			continue
+		}
+		if !s.hasCapacity(false) {
+			deferred++
+			continue
+		}
+		delete(s.superPeers, id)
+		conn.User.IsSuper = false
+		s.peers[id] = conn
+		changed = append(changed, conn)
+	}
+	if deferred > 0 {
+		s.logger.Warn("Deferred super peer demotions, no regular slot free", zap.Int("count", deferred))
+	}
+
+	if len(changed) > 0 {
+		// Promotions free regular slots
+		s.notifySlotFreed()
+	}
+	s.mu.Unlock()
+
+	for _, conn := range changed {
+		s.persistRole(conn)
+	}
+}
+
+// persistRole writes a peer's new role to its user record and session
+func (s *Service) persistRole(conn *PeerConnection) {
+	s.mu.RLock()
+	peerID, isSuper := conn.User.ID, conn.User.IsSuper
+	changed := peerDTO(conn)
+	s.mu.RUnlock()
+
+	if err := s.db.GetDB().Model(&db.User{}).Where("id = ?", peerID).Update("is_super", isSuper).Error; err != nil {
+		s.logger.Error("Failed to persist peer role", zap.Error(err), zap.String("peer_id", peerID))
+	}
+	if err := s.db.GetDB().Model(&db.PeerSession{}).Where("peer_id = ?", peerID).Update("is_super", isSuper).Error; err != nil {
+		s.logger.Error("Failed to persist peer session role", zap.Error(err), zap.String("peer_id", peerID))
+	}
+
+	s.events.Publish(EventPeerRoleChanged, peerID, changed)
+
+	if isSuper {
+		s.logger.Info("Peer promoted to super peer", zap.String("peer_id", peerID))
+	} else {
+		s.logger.Info("Super peer demoted to regular peer", zap.String("peer_id", peerID))
+	}
+}
@@ -0,0 +1,149 @@
+package p2p
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published on the presence stream
+const (
+	EventPeerJoined       = "peer-joined"
+	EventPeerLeft         = "peer-left"
+	EventPeerTimedOut     = "peer-timed-out"
+	EventPeerRoleChanged  = "peer-role-changed"
+	EventPeerReachability = "peer-reachability-changed"
+	EventFileShared       = "file-shared"
+	EventFileUpdated      = "file-updated"
+	EventFileRemoved      = "file-removed"
+	EventLibrarySynced    = "library-synced"
+	EventRelayRequested   = "relay-requested"
+	EventPunchRequested   = "punch-requested"
+	EventPunchUpdated     = "punch-updated"
+)
+
+// Event is a network transition published by the service
+type Event struct {
+	ID     string      `json:"id"` // Cursor a subscriber can resume from
+	Type   string      `json:"type"`
+	PeerID string      `json:"peer_id"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// EventBus fans service events out to subscribers and keeps a bounded history so
+// that a subscriber reconnecting with its last cursor does not miss transitions
+type EventBus struct {
+	mu          sync.Mutex
+	epoch       int64 // Distinguishes cursors handed out before a restart
+	seq         uint64
+	history     []Event
+	size        int
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates an event bus remembering the last size events
+func NewEventBus(size int) *EventBus {
+	return &EventBus{
+		epoch:       time.Now().UnixNano(),
+		size:        size,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish records an event and delivers it to every subscriber. Subscribers too
+// slow to keep up are dropped; they can reconnect with their last cursor.
+func (b *EventBus) Publish(eventType, peerID string, data interface{}) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event := Event{
+		ID:     fmt.Sprintf("%d-%d", b.epoch, b.seq),
+		Type:   eventType,
+		PeerID: peerID,
+		Time:   time.Now(),
+		Data:   data,
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.size {
+		b.history = b.history[len(b.history)-b.size:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Subscribe starts a subscription after the given cursor. It returns the events
+// to replay, the channel live events arrive on, and whether the replay is
+// complete; it is not when the cursor is older than the retained history or was
+// issued before a restart, in which case the subscriber should resynchronise.
+// An empty cursor subscribes to live events only.
+func (b *EventBus) Subscribe(cursor string) (replay []Event, live <-chan Event, complete bool, cancel func()) {
+	ch := make(chan Event, 64)
+
+	b.mu.Lock()
+	complete = true
+	if cursor != "" {
+		epoch, seq, ok := parseCursor(cursor)
+		switch {
+		case !ok || epoch != b.epoch || seq > b.seq:
+			complete = false
+			replay = append(replay, b.history...)
+		case len(b.history) > 0 && seq+1 < b.sequenceOf(b.history[0]):
+			complete = false
+			replay = append(replay, b.history...)
+		default:
+			for _, event := range b.history {
+				if b.sequenceOf(event) > seq {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, complete, cancel
+}
+
+// sequenceOf returns the sequence number of an event published by this bus
+func (b *EventBus) sequenceOf(event Event) uint64 {
+	_, seq, _ := parseCursor(event.ID)
+	return seq
+}
+
+// parseCursor splits an event ID into its epoch and sequence number
+func parseCursor(cursor string) (int64, uint64, bool) {
+	parts := strings.SplitN(cursor, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	epoch, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return epoch, seq, true
+}
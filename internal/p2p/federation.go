@@ -0,0 +1,558 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inventor7/p2p/internal/db"
+	"github.com/inventor7/p2p/internal/index"
+	"go.uber.org/zap"
+)
+
+// ErrDuplicateQuery is returned when a forwarded search reaches a server that already answered it
+var ErrDuplicateQuery = errors.New("search query already handled")
+
+// FederatedPeer is a peer connected to another super-peer server
+type FederatedPeer struct {
+	ID         string `json:"id"`
+	Username   string `json:"name"`
+	IPAddress  string `json:"ip_address"`
+	ListenPort int    `json:"listen_port"`
+	IsSuper    bool   `json:"is_super"`
+}
+
+// FileSummary aggregates the files a server's online peers currently share
+type FileSummary struct {
+	Files        int64 `json:"files"`
+	UniqueHashes int64 `json:"unique_hashes"`
+	TotalBytes   int64 `json:"total_bytes"`
+}
+
+// FederationPresence is what super-peer servers exchange when registering with each other
+type FederationPresence struct {
+	ServerID      string          `json:"server_id"`
+	URL           string          `json:"url"`
+	MaxPeers      int             `json:"max_peers"`
+	MaxSuperPeers int             `json:"max_super_peers"`
+	Peers         []FederatedPeer `json:"peers"`
+	Files         FileSummary     `json:"files"`
+}
+
+// FederationNode is a neighbour server together with the presence it last reported
+type FederationNode struct {
+	FederationPresence
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// FederatedSearchRequest is a search forwarded between super-peer servers
+type FederatedSearchRequest struct {
+	QueryID  string   `json:"query_id"`
+	Query    string   `json:"query"`
+	HopsLeft int      `json:"hops_left"`
+	Visited  []string `json:"visited"` // Server IDs that already ran the query
+	// Filters to apply; servers that predate them fall back to Query alone
+	Options *index.SearchOptions `json:"options,omitempty"`
+}
+
+// FederatedSearchResponse carries the merged results of a forwarded search
+type FederatedSearchResponse struct {
+	ServerID string              `json:"server_id"`
+	Results  []*FileSearchResult `json:"results"`
+}
+
+// Result sets of network searches kept for paging
+const (
+	networkSearchTTL = 5 * time.Minute
+	maxNetworkSearch = 256
+)
+
+// networkSearch is the merged result set of a network search, which the cursors
+// of its later pages refer to
+type networkSearch struct {
+	signature string // Encoded options the search ran with
+	results   []*FileSearchResult
+	createdAt time.Time
+}
+
+// federation holds the state this server keeps about its neighbours
+type federation struct {
+	client      *http.Client
+	nodes       map[string]*FederationNode // Keyed by server ID
+	seenQueries map[string]time.Time
+	searches    map[string]*networkSearch // Keyed by query ID
+	mu          sync.RWMutex
+}
+
+func newFederation() *federation {
+	return &federation{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		nodes:       make(map[string]*FederationNode),
+		seenQueries: make(map[string]time.Time),
+		searches:    make(map[string]*networkSearch),
+	}
+}
+
+// FederationEnabled reports whether this server takes part in a federation. It
+// only does with a shared secret configured, since neighbours are trusted with
+// the URLs this server calls and hands out to peers.
+func (s *Service) FederationEnabled() bool {
+	return s.cfg.FederationSecret != ""
+}
+
+// runFederation periodically registers with the configured and known neighbours
+// and forgets the ones that stopped answering
+func (s *Service) runFederation() {
+	interval := time.Duration(s.cfg.FederationSyncInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.syncFederation(context.Background())
+		s.pruneFederation(3 * interval)
+		<-ticker.C
+	}
+}
+
+// syncFederation exchanges presence with every configured seed and known neighbour
+func (s *Service) syncFederation(ctx context.Context) {
+	targets := make(map[string]struct{})
+	for _, url := range s.cfg.FederationPeers {
+		targets[strings.TrimRight(url, "/")] = struct{}{}
+	}
+	for _, node := range s.FederationNodes() {
+		targets[node.URL] = struct{}{}
+	}
+	delete(targets, strings.TrimRight(s.cfg.PublicURL, "/"))
+
+	presence, err := s.LocalPresence(ctx)
+	if err != nil {
+		s.logger.Error("Failed to build federation presence", zap.Error(err))
+		return
+	}
+
+	for url := range targets {
+		var remote FederationPresence
+		if err := s.postFederation(ctx, url+"/api/federation/register", presence, &remote); err != nil {
+			s.logger.Debug("Federation neighbour did not answer", zap.String("url", url), zap.Error(err))
+			continue
+		}
+		s.RecordFederationPresence(&remote)
+	}
+}
+
+// pruneFederation drops neighbours that have been silent for longer than maxAge
+// and forgets query IDs old enough that they can no longer loop back
+func (s *Service) pruneFederation(maxAge time.Duration) {
+	s.federation.mu.Lock()
+	defer s.federation.mu.Unlock()
+
+	for id, node := range s.federation.nodes {
+		if time.Since(node.LastSeen) > maxAge {
+			s.logger.Info("Federation neighbour timed out", zap.String("server_id", id), zap.String("url", node.URL))
+			delete(s.federation.nodes, id)
+		}
+	}
+	for queryID, seen := range s.federation.seenQueries {
+		if time.Since(seen) > maxAge {
+			delete(s.federation.seenQueries, queryID)
+		}
+	}
+}
+
+// RecordFederationPresence registers or refreshes a neighbour server
+func (s *Service) RecordFederationPresence(presence *FederationPresence) {
+	if !s.FederationEnabled() || presence.ServerID == "" || presence.ServerID == s.cfg.ServerID {
+		return
+	}
+	presence.URL = strings.TrimRight(presence.URL, "/")
+
+	s.federation.mu.Lock()
+	_, known := s.federation.nodes[presence.ServerID]
+	s.federation.nodes[presence.ServerID] = &FederationNode{
+		FederationPresence: *presence,
+		LastSeen:           time.Now(),
+	}
+	s.federation.mu.Unlock()
+
+	if !known {
+		s.logger.Info("Federation neighbour registered",
+			zap.String("server_id", presence.ServerID),
+			zap.String("url", presence.URL),
+			zap.Int("peers", len(presence.Peers)))
+	}
+}
+
+// FederationNodes returns the neighbour servers currently known
+func (s *Service) FederationNodes() []*FederationNode {
+	s.federation.mu.RLock()
+	defer s.federation.mu.RUnlock()
+
+	nodes := make([]*FederationNode, 0, len(s.federation.nodes))
+	for _, node := range s.federation.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// LocalPresence describes this server to its neighbours
+func (s *Service) LocalPresence(ctx context.Context) (*FederationPresence, error) {
+	presence := &FederationPresence{
+		ServerID:      s.cfg.ServerID,
+		URL:           strings.TrimRight(s.cfg.PublicURL, "/"),
+		MaxPeers:      s.cfg.MaxPeers,
+		MaxSuperPeers: s.cfg.MaxSuperPeers,
+		Peers:         []FederatedPeer{},
+	}
+
+	s.mu.RLock()
+	ownerIDs := make([]string, 0, len(s.peers)+len(s.superPeers))
+	for _, peerMap := range []map[string]*PeerConnection{s.peers, s.superPeers} {
+		for _, conn := range peerMap {
+			if !conn.IsActive {
+				continue
+			}
+			ownerIDs = append(ownerIDs, conn.User.ID)
+			presence.Peers = append(presence.Peers, FederatedPeer{
+				ID:         conn.User.ID,
+				Username:   conn.User.Username,
+				IPAddress:  conn.IPAddress,
+				ListenPort: conn.ListenPort,
+				IsSuper:    conn.User.IsSuper,
+			})
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(ownerIDs) == 0 {
+		return presence, nil
+	}
+
+	err := s.db.GetDB().Model(&db.File{}).
+		Select("COUNT(*) AS files, COUNT(DISTINCT hash) AS unique_hashes, COALESCE(SUM(size), 0) AS total_bytes").
+		Where("owner_id IN ?", ownerIDs).
+		Scan(&presence.Files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize shared files: %w", err)
+	}
+	return presence, nil
+}
+
+// SearchResults is one page of a network-wide search
+type SearchResults struct {
+	Files      []*FileSearchResult `json:"files"`
+	Total      int                 `json:"total"`                 // Matches across all pages
+	NextCursor string              `json:"next_cursor,omitempty"` // Empty on the last page
+	Suggestion string              `json:"suggestion,omitempty"`  // Corrected query when terms were misspelled
+}
+
+// SearchNetwork searches the files shared on this server and forwards the query to
+// neighbour servers, merging their results with the origin of each file attached.
+// The merged results are ordered and paged here.
+//
+// The first page runs the search across the network; its cursor refers to the
+// merged result set, which later pages are taken from for networkSearchTTL. Once
+// the set has expired a cursor runs the search again and continues after the last
+// result returned, so files shared or gone since may be skipped or repeated.
+//
+// Space filters are rejected: anyone can search the network, while the files of a
+// space are only visible to its members through index.Service.SearchFiles.
+func (s *Service) SearchNetwork(ctx context.Context, opts *index.SearchOptions) (*SearchResults, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+	if opts.SpaceID != "" {
+		return nil, fmt.Errorf("%w: space filters are only available when searching your spaces", index.ErrInvalidSearchOptions)
+	}
+	signature, err := json.Marshal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode search options: %w", err)
+	}
+
+	var queryID string
+	var results []*FileSearchResult
+	found := false
+	if opts.Cursor != "" {
+		var ok bool
+		queryID, opts.Cursor, ok = strings.Cut(opts.Cursor, ".")
+		if !ok {
+			return nil, index.ErrInvalidCursor
+		}
+		if results, found, err = s.networkSearchResults(queryID, string(signature)); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		queryID = uuid.New().String()
+		resp, err := s.HandleFederatedSearch(ctx, &FederatedSearchRequest{
+			QueryID:  queryID,
+			Query:    opts.Query,
+			HopsLeft: s.cfg.FederationHopLimit,
+			Options:  opts,
+		})
+		if err != nil {
+			return nil, err
+		}
+		results = resp.Results
+		normalizeRelevance(results)
+	}
+
+	keys := make([]index.SortKey, len(results))
+	for i, result := range results {
+		keys[i] = opts.Key(result.Origin+"/"+result.ID, &result.File, result.Relevance, result.Downloads)
+		if opts.Sort == index.SortRelevance {
+			// Among equally relevant copies the best source comes first
+			keys[i].Secondary = result.SourceScore
+		}
+	}
+	positions, next, err := opts.Paginate(keys)
+	if err != nil {
+		return nil, err
+	}
+	if next != "" {
+		if !found {
+			s.storeNetworkSearch(queryID, string(signature), results)
+		}
+		next = queryID + "." + next
+	}
+
+	page := &SearchResults{
+		Files:      make([]*FileSearchResult, 0, len(positions)),
+		Total:      len(results),
+		NextCursor: next,
+		Suggestion: index.SuggestQuery(s.search, opts.Query),
+	}
+	for _, i := range positions {
+		page.Files = append(page.Files, results[i])
+	}
+	return page, nil
+}
+
+// networkSearchResults returns the result set a page cursor refers to, if it is
+// still kept. A cursor used with options other than the ones it was issued for is
+// rejected.
+func (s *Service) networkSearchResults(queryID, signature string) ([]*FileSearchResult, bool, error) {
+	s.federation.mu.RLock()
+	defer s.federation.mu.RUnlock()
+
+	search, ok := s.federation.searches[queryID]
+	if !ok || time.Since(search.createdAt) > networkSearchTTL {
+		return nil, false, nil
+	}
+	if search.signature != signature {
+		return nil, false, fmt.Errorf("%w: cursor belongs to a different search", index.ErrInvalidCursor)
+	}
+	return search.results, true, nil
+}
+
+// storeNetworkSearch keeps the result set of a network search for its later
+// pages, dropping expired sets and, past maxNetworkSearch, the oldest one
+func (s *Service) storeNetworkSearch(queryID, signature string, results []*FileSearchResult) {
+	s.federation.mu.Lock()
+	defer s.federation.mu.Unlock()
+
+	var oldestID string
+	var oldest time.Time
+	for id, search := range s.federation.searches {
+		if time.Since(search.createdAt) > networkSearchTTL {
+			delete(s.federation.searches, id)
+		} else if oldestID == "" || search.createdAt.Before(oldest) {
+			oldestID, oldest = id, search.createdAt
+		}
+	}
+	if len(s.federation.searches) >= maxNetworkSearch {
+		delete(s.federation.searches, oldestID)
+	}
+	s.federation.searches[queryID] = &networkSearch{signature: signature, results: results, createdAt: time.Now()}
+}
+
+// normalizeRelevance scales relevance so that the best match of every origin
+// server scores 1. Scores such as BM25 depend on each server's own index and are
+// only comparable within one server's results.
+func normalizeRelevance(results []*FileSearchResult) {
+	best := make(map[string]float64)
+	for _, result := range results {
+		if result.Relevance > best[result.Origin] {
+			best[result.Origin] = result.Relevance
+		}
+	}
+	for _, result := range results {
+		if top := best[result.Origin]; top > 0 {
+			result.Relevance /= top
+		}
+	}
+}
+
+// HandleFederatedSearch answers a search locally and, while hops remain, forwards it
+// to every neighbour that has not seen it yet
+func (s *Service) HandleFederatedSearch(ctx context.Context, req *FederatedSearchRequest) (*FederatedSearchResponse, error) {
+	if !s.markQuerySeen(req.QueryID) {
+		return nil, ErrDuplicateQuery
+	}
+
+	opts := req.Options
+	if opts == nil {
+		opts = &index.SearchOptions{Query: req.Query}
+	}
+	results, err := s.SearchSharedFiles(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		result.Origin = s.cfg.ServerID
+	}
+
+	if req.HopsLeft > 0 {
+		remote := s.forwardSearch(ctx, req)
+		results = mergeSearchResults(results, remote)
+	}
+
+	return &FederatedSearchResponse{ServerID: s.cfg.ServerID, Results: results}, nil
+}
+
+// markQuerySeen records a forwarded query ID, reporting false if this server
+// already handled it
+func (s *Service) markQuerySeen(queryID string) bool {
+	s.federation.mu.Lock()
+	defer s.federation.mu.Unlock()
+
+	if _, seen := s.federation.seenQueries[queryID]; seen {
+		return false
+	}
+	s.federation.seenQueries[queryID] = time.Now()
+	return true
+}
+
+// forwardTargets returns the neighbours a query that already visited the given
+// servers is forwarded to, and the visited list to forward it with
+func (s *Service) forwardTargets(visited []string) ([]*FederationNode, []string) {
+	seen := make(map[string]struct{}, len(visited)+1)
+	for _, id := range visited {
+		seen[id] = struct{}{}
+	}
+	seen[s.cfg.ServerID] = struct{}{}
+
+	var targets []*FederationNode
+	for _, node := range s.FederationNodes() {
+		if _, ok := seen[node.ServerID]; !ok {
+			targets = append(targets, node)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	forwarded := append(append([]string{}, visited...), s.cfg.ServerID)
+	for _, node := range targets {
+		forwarded = append(forwarded, node.ServerID)
+	}
+	return targets, forwarded
+}
+
+// forwardSearch sends a search to the neighbours that have not run it yet and
+// collects whatever they answer within the request deadline
+func (s *Service) forwardSearch(ctx context.Context, req *FederatedSearchRequest) []*FileSearchResult {
+	targets, visited := s.forwardTargets(req.Visited)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	forwarded := &FederatedSearchRequest{
+		QueryID:  req.QueryID,
+		Query:    req.Query,
+		HopsLeft: req.HopsLeft - 1,
+		Visited:  visited,
+		Options:  req.Options,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []*FileSearchResult
+	)
+	for _, node := range targets {
+		wg.Add(1)
+		go func(node *FederationNode) {
+			defer wg.Done()
+
+			var resp FederatedSearchResponse
+			if err := s.postFederation(ctx, node.URL+"/api/federation/search", forwarded, &resp); err != nil {
+				s.logger.Debug("Federated search failed", zap.String("server_id", node.ServerID), zap.Error(err))
+				return
+			}
+			for _, result := range resp.Results {
+				if result.Origin == "" {
+					result.Origin = node.ServerID
+				}
+			}
+
+			mu.Lock()
+			results = append(results, resp.Results...)
+			mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// mergeSearchResults appends remote results, skipping files already present from the same origin
+func mergeSearchResults(local, remote []*FileSearchResult) []*FileSearchResult {
+	seen := make(map[string]struct{}, len(local)+len(remote))
+	for _, result := range local {
+		seen[result.Origin+"/"+result.ID] = struct{}{}
+	}
+	for _, result := range remote {
+		key := result.Origin + "/" + result.ID
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		local = append(local, result)
+	}
+	return local
+}
+
+// postFederation sends a JSON request to a neighbour server and decodes its answer
+func (s *Service) postFederation(ctx context.Context, url string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode federation request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build federation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.FederationSecret != "" {
+		req.Header.Set("X-Federation-Secret", s.cfg.FederationSecret)
+	}
+
+	resp, err := s.federation.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("federation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("federation request returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode federation response: %w", err)
+	}
+	return nil
+}
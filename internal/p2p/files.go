@@ -0,0 +1,211 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrNotFileOwner is returned when a peer tries to change a file shared by another peer
+var ErrNotFileOwner = errors.New("file is owned by another peer")
+
+// FileUpdate carries the metadata a peer may change on one of its shared files.
+// Nil fields are left untouched. Changing the content (hash or size) without a
+// new Merkle root drops the old one, since its chunk hashes no longer apply.
+type FileUpdate struct {
+	Name          *string
+	Size          *int64
+	Hash          *string
+	Type          *string
+	LastModified  *time.Time
+	HashAlgorithm *string
+	MerkleRoot    *string
+	ChunkSize     *int64
+	ChunkHashes   []string
+}
+
+// ownedFile loads a file and checks that it belongs to the calling peer
+func (s *Service) ownedFile(peerID, fileID string) (*db.File, error) {
+	var file db.File
+	if err := s.db.GetDB().Where("id = ?", fileID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to load file: %w", err)
+	}
+	if file.OwnerID != peerID {
+		return nil, ErrNotFileOwner
+	}
+	return &file, nil
+}
+
+// OnFileShared registers a callback run for newly shared files. Files shared
+// together, such as the files a library sync adds, are handed over in one call.
+// Callbacks run in the background once the files are stored. Must be called
+// before peers start sharing.
+func (s *Service) OnFileShared(fn func(ctx context.Context, files []*db.File)) {
+	s.fileShared = append(s.fileShared, fn)
+}
+
+// notifyFileShared hands newly shared files to the registered callbacks
+func (s *Service) notifyFileShared(ctx context.Context, files ...*db.File) {
+	if len(s.fileShared) == 0 || len(files) == 0 {
+		return
+	}
+	// The callbacks outlive the request that shared the files
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, fn := range s.fileShared {
+			fn(ctx, files)
+		}
+	}()
+}
+
+// UnshareFile withdraws one of the peer's shared files, removing it from every
+// space it was added to
+func (s *Service) UnshareFile(ctx context.Context, peerID, fileID string) error {
+	file, err := s.ownedFile(peerID, fileID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		return deleteFileRecords(tx, []string{file.ID})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.uncacheSharedFile(peerID, file.ID)
+	s.search.Remove(file.ID)
+	s.removePreviews(file.ID)
+	s.events.Publish(EventFileRemoved, peerID, file)
+
+	s.logger.Info("Peer unshared file", zap.String("peer_id", peerID), zap.String("file_id", fileID))
+	return nil
+}
+
+// UpdateSharedFile changes the metadata of one of the peer's shared files, e.g.
+// after it was modified on disk
+func (s *Service) UpdateSharedFile(ctx context.Context, peerID, fileID string, update *FileUpdate) (*db.File, error) {
+	file, err := s.ownedFile(peerID, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	contentChanged := (update.Hash != nil && *update.Hash != file.Hash) ||
+		(update.Size != nil && *update.Size != file.Size)
+
+	if update.Name != nil {
+		file.Name = *update.Name
+	}
+	if update.Size != nil {
+		file.Size = *update.Size
+	}
+	if update.Hash != nil {
+		file.Hash = *update.Hash
+	}
+	if update.Type != nil {
+		file.Type = *update.Type
+	}
+
+	chunkHashes := update.ChunkHashes
+	if update.MerkleRoot != nil {
+		file.MerkleRoot = *update.MerkleRoot
+		file.HashAlgorithm = ""
+		file.ChunkSize = 0
+		if update.HashAlgorithm != nil {
+			file.HashAlgorithm = *update.HashAlgorithm
+		}
+		if update.ChunkSize != nil {
+			file.ChunkSize = *update.ChunkSize
+		}
+	} else if contentChanged {
+		file.MerkleRoot, file.HashAlgorithm, file.ChunkSize = "", "", 0
+	} else if file.MerkleRoot != "" {
+		// Keep the stored tree; it is rewritten unchanged
+		if chunkHashes, err = s.storedChunkHashes(file.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// A preview of the old content would be misleading
+	stalePreview := contentChanged && file.PreviewURL != ""
+	if stalePreview {
+		file.PreviewURL = ""
+	}
+
+	switch {
+	case update.LastModified != nil:
+		file.LastModified = *update.LastModified
+	case contentChanged:
+		file.LastModified = time.Now()
+	}
+
+	if err := s.prepareFile(file, chunkHashes); err != nil {
+		return nil, err
+	}
+
+	err = s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		return updateFileRecord(tx, file, chunkHashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if stalePreview {
+		s.removePreviews(file.ID)
+	}
+	s.cacheSharedFile(peerID, file)
+	s.search.Index(file)
+	s.events.Publish(EventFileUpdated, peerID, file)
+
+	s.logger.Info("Peer updated shared file", zap.String("peer_id", peerID), zap.String("file_id", fileID))
+	return file, nil
+}
+
+// storedChunkHashes returns the chunk hashes kept for a file's Merkle tree
+func (s *Service) storedChunkHashes(fileID string) ([]string, error) {
+	var tree db.FileMerkleTree
+	if err := s.db.GetDB().Where("file_id = ?", fileID).First(&tree).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load merkle tree: %w", err)
+	}
+	if tree.Leaves == "" {
+		return []string{}, nil
+	}
+	return strings.Split(tree.Leaves, ","), nil
+}
+
+// cacheSharedFile records a shared file on the owner's connection, whether it is
+// a regular or a super peer
+func (s *Service) cacheSharedFile(peerID string, file *db.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if conn, ok := s.peers[peerID]; ok {
+		conn.Files[file.ID] = file
+	} else if conn, ok := s.superPeers[peerID]; ok {
+		conn.Files[file.ID] = file
+	}
+}
+
+// uncacheSharedFile removes a withdrawn file from the owner's connection
+func (s *Service) uncacheSharedFile(peerID, fileID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if conn, ok := s.peers[peerID]; ok {
+		delete(conn.Files, fileID)
+	} else if conn, ok := s.superPeers[peerID]; ok {
+		delete(conn.Files, fileID)
+	}
+}
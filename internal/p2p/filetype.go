@@ -0,0 +1,90 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupportedFileType is returned when a shared file's type is not in AllowedFileTypes
+var ErrUnsupportedFileType = errors.New("unsupported file type")
+
+// extensionTypes covers common extensions missing from Go's built-in MIME table,
+// which is all there is on hosts without a system mime.types file
+var extensionTypes = map[string]string{
+	".txt":  "text/plain",
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".mp4":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".mov":  "video/quicktime",
+	".zip":  "application/zip",
+	".rar":  "application/x-rar-compressed",
+	".7z":   "application/x-7z-compressed",
+}
+
+// resolveFileType determines a file's MIME type from the type the peer declared,
+// falling back to the file name's extension, and checks it against the allow-list
+func (s *Service) resolveFileType(declared, name string) (string, error) {
+	fileType := normalizeMediaType(declared)
+	if fileType == "" {
+		ext := strings.ToLower(filepath.Ext(name))
+		fileType = normalizeMediaType(mime.TypeByExtension(ext))
+		if fileType == "" {
+			fileType = extensionTypes[ext]
+		}
+	}
+	if fileType == "" {
+		return "", fmt.Errorf("%w: could not determine the type of %q, send file_type", ErrUnsupportedFileType, name)
+	}
+
+	if !s.fileTypeAllowed(fileType) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFileType, fileType)
+	}
+	return fileType, nil
+}
+
+// fileTypeAllowed matches a MIME type against the configured patterns, which may
+// use a "*" subtype (e.g. image/*). An empty allow-list accepts every type.
+func (s *Service) fileTypeAllowed(fileType string) bool {
+	if len(s.cfg.AllowedFileTypes) == 0 {
+		return true
+	}
+
+	major, _, _ := strings.Cut(fileType, "/")
+	for _, pattern := range s.cfg.AllowedFileTypes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "*/*" || pattern == fileType:
+			return true
+		case strings.HasSuffix(pattern, "/*") && strings.TrimSuffix(pattern, "/*") == major:
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedFileTypes returns the MIME patterns files may be shared with
+func (s *Service) AllowedFileTypes() []string {
+	return s.cfg.AllowedFileTypes
+}
+
+// normalizeMediaType lowercases a MIME type and strips its parameters, returning
+// an empty string if it is not a valid type/subtype pair
+func normalizeMediaType(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil || !strings.Contains(mediaType, "/") {
+		return ""
+	}
+	return mediaType
+}
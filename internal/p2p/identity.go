@@ -0,0 +1,222 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidPeerCredential is returned when a peer tries to resume an identity it cannot prove it owns
+	ErrInvalidPeerCredential = errors.New("invalid peer credential")
+	// ErrPeerNameTaken is returned when a new peer picks a name another identity already uses
+	ErrPeerNameTaken = errors.New("peer name is already taken")
+)
+
+// newPeerCredential generates the secret a peer presents to resume its identity,
+// along with the hash stored in place of a password
+func newPeerCredential() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate peer credential: %w", err)
+	}
+	credential := hex.EncodeToString(secret)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(credential), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to hash peer credential: %w", err)
+	}
+	return credential, string(hash), nil
+}
+
+// credentialDigest is what the credential of a connected peer is checked against
+// on every request, so that bcrypt only runs when a peer joins
+func credentialDigest(credential string) [32]byte {
+	return sha256.Sum256([]byte(credential))
+}
+
+// verifyCredential loads a peer identity and checks the credential presented for it
+func (s *Service) verifyCredential(peerID, credential string) (*db.User, error) {
+	var user db.User
+	if err := s.db.GetDB().First(&user, "id = ?", peerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidPeerCredential
+		}
+		return nil, fmt.Errorf("failed to look up peer: %w", err)
+	}
+
+	// Identities issued before credentials existed cannot be resumed
+	if user.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credential)) != nil {
+		s.logger.Warn("Peer presented an invalid credential", zap.String("peer_id", peerID))
+		return nil, ErrInvalidPeerCredential
+	}
+	return &user, nil
+}
+
+// AuthenticatePeer checks the credential a peer sends with its requests. A
+// connected peer is checked against the credential it joined with. A peer whose
+// session was restored after a server restart is checked against the hash stored
+// for its identity and brought back online by its first authenticated request.
+// Any other peer has to join first and gets ErrPeerNotFound.
+func (s *Service) AuthenticatePeer(ctx context.Context, peerID, credential string) error {
+	if peerID == "" || credential == "" {
+		return ErrInvalidPeerCredential
+	}
+
+	s.mu.RLock()
+	conn, ok := s.peers[peerID]
+	if !ok {
+		conn, ok = s.superPeers[peerID]
+	}
+	var expected [32]byte
+	if ok {
+		expected = conn.credential
+	}
+	s.mu.RUnlock()
+
+	if ok {
+		given := credentialDigest(credential)
+		if subtle.ConstantTimeCompare(given[:], expected[:]) != 1 {
+			return ErrInvalidPeerCredential
+		}
+		return nil
+	}
+	// resumeSession returns ErrPeerNotFound before checking the credential for peers
+	// without a restored session, so unknown peers cannot make the server run bcrypt
+	_, err := s.resumeSession(ctx, peerID, credential)
+	return err
+}
+
+// claimPeerName checks that a peer name is free for the given identity (empty for
+// a new one). Identities issued before credentials existed can never be resumed,
+// so their names are released: they are renamed to their ID.
+func (s *Service) claimPeerName(name, peerID string) error {
+	var holder db.User
+	if err := s.db.GetDB().Where("username = ?", name).First(&holder).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to check peer name: %w", err)
+	}
+	if holder.ID == peerID {
+		return nil
+	}
+	if holder.PasswordHash != "" || s.isConnected(holder.ID) {
+		return ErrPeerNameTaken
+	}
+
+	if err := s.db.GetDB().Model(&holder).Update("username", holder.ID).Error; err != nil {
+		return fmt.Errorf("failed to release peer name: %w", err)
+	}
+	s.logger.Info("Released the name of an identity without credential",
+		zap.String("peer_name", name),
+		zap.String("previous_peer_id", holder.ID))
+	return nil
+}
+
+// loadSharedFiles reads every file a peer has shared into a connection cache
+func (s *Service) loadSharedFiles(peerID string) (map[string]*db.File, error) {
+	var files []*db.File
+	if err := s.db.GetDB().Where("owner_id = ?", peerID).Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("failed to load shared files: %w", err)
+	}
+
+	cache := make(map[string]*db.File, len(files))
+	for _, file := range files {
+		cache[file.ID] = file
+	}
+	return cache, nil
+}
+
+// rejoinPeer restores a known peer identity after verifying its credential. The
+// peer keeps its ID, so its shared files and space memberships come back with it.
+func (s *Service) rejoinPeer(ctx context.Context, reg PeerRegistration) (*JoinResult, error) {
+	found, err := s.verifyCredential(reg.PeerID, reg.Credential)
+	if err != nil {
+		return nil, err
+	}
+	user := *found
+
+	// A peer that is still connected keeps its slot; anyone else needs a new one
+	isSuper := s.grantsSuperOnJoin(reg)
+	needsSlot := !s.isConnected(user.ID)
+	if needsSlot {
+		if err := s.acquireSlot(ctx, isSuper); err != nil {
+			return nil, err
+		}
+	}
+	joined := false
+	defer func() {
+		if needsSlot {
+			s.releaseSlot(isSuper, joined)
+		}
+	}()
+
+	user.IsSuper = isSuper
+	user.LastSeen = time.Now()
+	updates := map[string]interface{}{
+		"is_super":  user.IsSuper,
+		"last_seen": user.LastSeen,
+	}
+	if reg.PublicKey != "" && reg.PublicKey != user.PublicKey {
+		// Peers may rotate their signing key; receipts are verified against the current one
+		user.PublicKey = reg.PublicKey
+		updates["public_key"] = user.PublicKey
+	}
+	if reg.PeerName != "" && reg.PeerName != user.Username {
+		if err := s.claimPeerName(reg.PeerName, user.ID); err != nil {
+			return nil, err
+		}
+		user.Username = reg.PeerName
+		updates["username"] = user.Username
+	}
+	if err := s.db.GetDB().Model(&user).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update rejoining peer: %w", err)
+	}
+
+	files, err := s.loadSharedFiles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var spaceIDs []string
+	if err := s.db.GetDB().Model(&db.SpaceMember{}).Where("user_id = ?", user.ID).Pluck("space_id", &spaceIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load space memberships: %w", err)
+	}
+
+	conn := &PeerConnection{
+		User:       &user,
+		IPAddress:  reg.IPAddress,
+		ListenPort: reg.ListenPort,
+		LastPing:   time.Now(),
+		Files:      files,
+		IsActive:   true,
+		Disconnect: make(chan struct{}),
+		credential: credentialDigest(reg.Credential),
+	}
+	applyRegistration(conn, reg)
+	s.attachConnection(conn)
+	joined = true
+
+	s.logger.Info("Peer rejoined with its previous identity",
+		zap.String("peer_id", user.ID),
+		zap.String("username", user.Username),
+		zap.Int("files", len(files)),
+		zap.Int("spaces", len(spaceIDs)))
+
+	result := s.joinResult(conn)
+	result.Resumed = true
+	result.SharedFiles = len(files)
+	result.SpaceIDs = spaceIDs
+	return result, nil
+}
@@ -0,0 +1,317 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inventor7/p2p/internal/db"
+	"github.com/inventor7/p2p/internal/index"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrStaleLibraryVersion is returned when a delta sync is based on an outdated library version
+	ErrStaleLibraryVersion = errors.New("library version is stale")
+	// ErrInvalidLibrary is returned for sync manifests with missing or duplicate paths
+	ErrInvalidLibrary = errors.New("invalid library manifest")
+)
+
+// LibraryEntry is one file in a peer's library manifest, identified by its path on the peer
+type LibraryEntry struct {
+	Path          string
+	Name          string
+	Size          int64
+	Hash          string
+	Type          string
+	LastModified  time.Time
+	HashAlgorithm string
+	MerkleRoot    string
+	ChunkSize     int64
+	ChunkHashes   []string
+}
+
+// LibrarySync is a peer's library upload. A full sync lists every shared file and
+// replaces whatever was shared before; a delta lists changed files and removed
+// paths relative to BaseVersion.
+type LibrarySync struct {
+	Full        bool
+	BaseVersion int64
+	Files       []LibraryEntry
+	Removed     []string
+}
+
+// LibrarySyncResult summarises the changes applied by a sync
+type LibrarySyncResult struct {
+	Version   int64 `json:"version"`
+	Added     int   `json:"added"`
+	Updated   int   `json:"updated"`
+	Removed   int   `json:"removed"`
+	Unchanged int   `json:"unchanged"`
+}
+
+// StaleLibraryError is returned for a delta against an outdated version; the peer
+// should send a full sync or a delta from CurrentVersion
+type StaleLibraryError struct {
+	CurrentVersion int64
+}
+
+func (e *StaleLibraryError) Error() string {
+	return fmt.Sprintf("%s (current version %d)", ErrStaleLibraryVersion, e.CurrentVersion)
+}
+
+func (e *StaleLibraryError) Unwrap() error {
+	return ErrStaleLibraryVersion
+}
+
+// LibraryVersion returns the version of a peer's last library sync, zero if it never synced
+func (s *Service) LibraryVersion(ctx context.Context, peerID string) (int64, error) {
+	var library db.PeerLibrary
+	err := s.db.GetDB().Where("peer_id = ?", peerID).First(&library).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load library version: %w", err)
+	}
+	return library.Version, nil
+}
+
+// SyncLibrary applies a peer's library manifest to its shared files in a single
+// transaction and returns the new library version
+func (s *Service) SyncLibrary(ctx context.Context, peerID string, manifest *LibrarySync) (*LibrarySyncResult, error) {
+	if !s.isConnected(peerID) {
+		return nil, ErrPeerNotFound
+	}
+
+	// Validate every entry up front so a bad file rejects the whole sync
+	incoming := make(map[string]*db.File, len(manifest.Files))
+	chunkHashes := make(map[string][]string, len(manifest.Files))
+	undated := make(map[string]bool) // Entries sent without a modification time
+	for i := range manifest.Files {
+		entry := &manifest.Files[i]
+		if entry.Path == "" {
+			return nil, fmt.Errorf("%w: file %d has no path", ErrInvalidLibrary, i)
+		}
+		if _, dup := incoming[entry.Path]; dup {
+			return nil, fmt.Errorf("%w: duplicate path %q", ErrInvalidLibrary, entry.Path)
+		}
+
+		file := &db.File{
+			Name:          entry.Name,
+			Size:          entry.Size,
+			Hash:          entry.Hash,
+			Type:          entry.Type,
+			OwnerID:       peerID,
+			Path:          entry.Path,
+			LastModified:  entry.LastModified,
+			HashAlgorithm: entry.HashAlgorithm,
+			MerkleRoot:    entry.MerkleRoot,
+			ChunkSize:     entry.ChunkSize,
+		}
+		if err := s.prepareFile(file, entry.ChunkHashes); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		incoming[entry.Path] = file
+		chunkHashes[entry.Path] = entry.ChunkHashes
+		undated[entry.Path] = entry.LastModified.IsZero()
+	}
+
+	result := &LibrarySyncResult{}
+	var removed, stalePreviews []string
+	var changed, added []*db.File
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// The library row is locked until the sync commits, so concurrent syncs of
+		// the same peer are applied one after the other against the right version
+		library := db.PeerLibrary{PeerID: peerID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&library).Error; err != nil {
+			return fmt.Errorf("failed to create library version: %w", err)
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("peer_id = ?", peerID).First(&library).Error; err != nil {
+			return fmt.Errorf("failed to load library version: %w", err)
+		}
+		if !manifest.Full && manifest.BaseVersion != library.Version {
+			return &StaleLibraryError{CurrentVersion: library.Version}
+		}
+
+		var existing []*db.File
+		if err := tx.Where("owner_id = ?", peerID).Find(&existing).Error; err != nil {
+			return fmt.Errorf("failed to load shared files: %w", err)
+		}
+		byPath := make(map[string]*db.File, len(existing))
+		for _, file := range existing {
+			if file.Path != "" {
+				byPath[file.Path] = file
+			}
+		}
+
+		if manifest.Full {
+			// Everything not in the manifest is gone, including files shared without a path
+			for _, file := range existing {
+				if _, keep := incoming[file.Path]; !keep || file.Path == "" {
+					removed = append(removed, file.ID)
+				}
+			}
+		} else {
+			for _, path := range manifest.Removed {
+				if file, ok := byPath[path]; ok {
+					if _, readded := incoming[path]; !readded {
+						removed = append(removed, file.ID)
+					}
+				}
+			}
+		}
+		if err := deleteFileRecords(tx, removed); err != nil {
+			return err
+		}
+		result.Removed = len(removed)
+
+		for path, file := range incoming {
+			current, ok := byPath[path]
+			if !ok {
+				file.ID = uuid.New().String()
+				if err := createFileRecord(tx, file, chunkHashes[path]); err != nil {
+					return err
+				}
+				changed = append(changed, file)
+				added = append(added, file)
+				result.Added++
+				continue
+			}
+
+			if sameLibraryFile(current, file, !undated[path]) {
+				result.Unchanged++
+				continue
+			}
+			// Updated in place so the file keeps its ID, space memberships and,
+			// unless the content changed, its preview
+			file.ID = current.ID
+			if current.Hash == file.Hash && current.Size == file.Size {
+				file.PreviewURL = current.PreviewURL
+			} else if current.PreviewURL != "" {
+				stalePreviews = append(stalePreviews, current.ID)
+			}
+			if err := updateFileRecord(tx, file, chunkHashes[path]); err != nil {
+				return err
+			}
+			changed = append(changed, file)
+			result.Updated++
+		}
+
+		library.Version++
+		if err := tx.Save(&library).Error; err != nil {
+			return fmt.Errorf("failed to save library version: %w", err)
+		}
+		result.Version = library.Version
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.search.Remove(removed...)
+	s.search.Index(changed...)
+	s.removePreviews(append(removed, stalePreviews...)...)
+	s.refreshSharedFiles(peerID)
+	s.events.Publish(EventLibrarySynced, peerID, result)
+	s.notifyFileShared(ctx, added...)
+
+	s.logger.Info("Synced peer library",
+		zap.String("peer_id", peerID),
+		zap.Bool("full", manifest.Full),
+		zap.Int64("version", result.Version),
+		zap.Int("added", result.Added),
+		zap.Int("updated", result.Updated),
+		zap.Int("removed", result.Removed))
+	return result, nil
+}
+
+// sameLibraryFile reports whether a synced entry leaves a stored file unchanged.
+// Entries without a modification time are stamped with the sync time, so their
+// timestamp is only compared when the peer sent one.
+func sameLibraryFile(current, incoming *db.File, compareModified bool) bool {
+	return current.Name == incoming.Name &&
+		current.Size == incoming.Size &&
+		current.Hash == incoming.Hash &&
+		current.Type == incoming.Type &&
+		current.HashAlgorithm == incoming.HashAlgorithm &&
+		current.MerkleRoot == incoming.MerkleRoot &&
+		current.ChunkSize == incoming.ChunkSize &&
+		(!compareModified || current.LastModified.Equal(incoming.LastModified))
+}
+
+// createFileRecord stores a file and, if it has a Merkle root, its chunk hashes
+func createFileRecord(tx *gorm.DB, file *db.File, chunkHashes []string) error {
+	if err := tx.Create(file).Error; err != nil {
+		return fmt.Errorf("failed to save file metadata: %w", err)
+	}
+	if file.MerkleRoot == "" {
+		return nil
+	}
+	tree := &db.FileMerkleTree{FileID: file.ID, Leaves: strings.ToLower(strings.Join(chunkHashes, ","))}
+	if err := tx.Create(tree).Error; err != nil {
+		return fmt.Errorf("failed to save merkle tree: %w", err)
+	}
+	return nil
+}
+
+// updateFileRecord overwrites a stored file's metadata and replaces its chunk hashes
+func updateFileRecord(tx *gorm.DB, file *db.File, chunkHashes []string) error {
+	err := tx.Model(&db.File{ID: file.ID}).
+		Select("name", "size", "hash", "type", "last_modified", "hash_algorithm", "merkle_root", "chunk_size", "preview_url").
+		Updates(file).Error
+	if err != nil {
+		return fmt.Errorf("failed to update file metadata: %w", err)
+	}
+	if err := tx.Where("file_id = ?", file.ID).Delete(&db.FileMerkleTree{}).Error; err != nil {
+		return fmt.Errorf("failed to remove merkle tree: %w", err)
+	}
+	if file.MerkleRoot == "" {
+		return nil
+	}
+	tree := &db.FileMerkleTree{FileID: file.ID, Leaves: strings.ToLower(strings.Join(chunkHashes, ","))}
+	if err := tx.Create(tree).Error; err != nil {
+		return fmt.Errorf("failed to save merkle tree: %w", err)
+	}
+	return nil
+}
+
+// deleteFileRecords removes files together with their space memberships and
+// Merkle trees, in batches of index.MaxQueryValues IDs
+func deleteFileRecords(tx *gorm.DB, ids []string) error {
+	for start := 0; start < len(ids); start += index.MaxQueryValues {
+		batch := ids[start:min(start+index.MaxQueryValues, len(ids))]
+		if err := tx.Where("file_id IN ?", batch).Delete(&db.SpaceFile{}).Error; err != nil {
+			return fmt.Errorf("failed to remove files from spaces: %w", err)
+		}
+		if err := tx.Where("file_id IN ?", batch).Delete(&db.FileMerkleTree{}).Error; err != nil {
+			return fmt.Errorf("failed to remove merkle trees: %w", err)
+		}
+		if err := tx.Where("id IN ?", batch).Delete(&db.File{}).Error; err != nil {
+			return fmt.Errorf("failed to remove files: %w", err)
+		}
+	}
+	return nil
+}
+
+// refreshSharedFiles reloads a connected peer's cached file list from the database
+func (s *Service) refreshSharedFiles(peerID string) {
+	files, err := s.loadSharedFiles(peerID)
+	if err != nil {
+		s.logger.Error("Failed to refresh shared files", zap.Error(err), zap.String("peer_id", peerID))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if conn, ok := s.peers[peerID]; ok {
+		conn.Files = files
+	} else if conn, ok := s.superPeers[peerID]; ok {
+		conn.Files = files
+	}
+}
@@ -0,0 +1,243 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+)
+
+// HashOwner is one shared copy of a piece of content
+type HashOwner struct {
+	PeerID       string    `json:"peer_id"`
+	FileID       string    `json:"file_id"`
+	Name         string    `json:"name"`
+	LastModified time.Time `json:"last_modified"`
+	Online       bool      `json:"online"`
+	Origin       string    `json:"origin"` // ID of the super-peer server the owner is connected to
+}
+
+// HashLookup lists every copy of a piece of content shared across the network
+type HashLookup struct {
+	Hash      string      `json:"hash"`
+	Size      int64       `json:"size"`
+	Type      string      `json:"type"`
+	Names     []string    `json:"names"`  // Distinct names the content is shared under
+	Owners    []HashOwner `json:"owners"` // Online owners first
+	Downloads int64       `json:"downloads"`
+	Cached    bool        `json:"cached,omitempty"` // Whether a super peer's cache holds a copy
+}
+
+// FederatedLookupRequest is a hash lookup forwarded between super-peer servers
+type FederatedLookupRequest struct {
+	QueryID  string   `json:"query_id"`
+	Hash     string   `json:"hash"`
+	HopsLeft int      `json:"hops_left"`
+	Visited  []string `json:"visited"` // Server IDs that already ran the lookup
+}
+
+// FederatedLookupResponse carries the merged copies a forwarded lookup found.
+// Lookup is nil when no server reached shares the content.
+type FederatedLookupResponse struct {
+	ServerID string      `json:"server_id"`
+	Lookup   *HashLookup `json:"lookup,omitempty"`
+}
+
+// LookupHash returns every owner of the content with the given hash, online or
+// not, and the names they share it under. With federation enabled the lookup is
+// forwarded like a network search and the copies shared on other servers are
+// included. It returns ErrFileNotFound if nobody shares the content.
+func (s *Service) LookupHash(ctx context.Context, hash string) (*HashLookup, error) {
+	var lookup *HashLookup
+	if s.FederationEnabled() {
+		resp, err := s.HandleFederatedLookup(ctx, &FederatedLookupRequest{
+			QueryID:  uuid.New().String(),
+			Hash:     hash,
+			HopsLeft: s.cfg.FederationHopLimit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		lookup = resp.Lookup
+	} else {
+		var err error
+		if lookup, err = s.lookupLocalHash(ctx, hash); err != nil {
+			return nil, err
+		}
+	}
+	if lookup == nil {
+		return nil, ErrFileNotFound
+	}
+
+	sort.SliceStable(lookup.Owners, func(i, j int) bool {
+		return lookup.Owners[i].Online && !lookup.Owners[j].Online
+	})
+	return lookup, nil
+}
+
+// HandleFederatedLookup looks a hash up locally and, while hops remain, forwards
+// the lookup to every neighbour that has not seen it yet
+func (s *Service) HandleFederatedLookup(ctx context.Context, req *FederatedLookupRequest) (*FederatedLookupResponse, error) {
+	if !s.markQuerySeen(req.QueryID) {
+		return nil, ErrDuplicateQuery
+	}
+
+	lookup, err := s.lookupLocalHash(ctx, req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if req.HopsLeft > 0 {
+		for _, remote := range s.forwardLookup(ctx, req) {
+			lookup = mergeHashLookups(lookup, remote)
+		}
+	}
+
+	return &FederatedLookupResponse{ServerID: s.cfg.ServerID, Lookup: lookup}, nil
+}
+
+// lookupLocalHash collects the copies of the content shared on this server. It
+// returns nil if none is.
+func (s *Service) lookupLocalHash(ctx context.Context, hash string) (*HashLookup, error) {
+	var files []*db.File
+	if err := s.db.GetDB().WithContext(ctx).Where("hash = ?", hash).Order("created_at").Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up hash: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	lookup := &HashLookup{
+		Hash:   hash,
+		Size:   files[0].Size,
+		Type:   files[0].Type,
+		Names:  []string{},
+		Owners: make([]HashOwner, 0, len(files)),
+	}
+	named := make(map[string]bool)
+
+	s.mu.RLock()
+	for _, file := range files {
+		conn, ok := s.peers[file.OwnerID]
+		if !ok {
+			conn, ok = s.superPeers[file.OwnerID]
+		}
+		lookup.Owners = append(lookup.Owners, HashOwner{
+			PeerID:       file.OwnerID,
+			FileID:       file.ID,
+			Name:         file.Name,
+			LastModified: file.LastModified,
+			Online:       ok && conn.IsActive,
+			Origin:       s.cfg.ServerID,
+		})
+		if !named[file.Name] {
+			named[file.Name] = true
+			lookup.Names = append(lookup.Names, file.Name)
+		}
+	}
+	s.mu.RUnlock()
+
+	lookup.Downloads = s.downloadCounts([]string{hash})[hash]
+	// Looking a hash up is not a use of the cached copy, so it does not keep it from eviction
+	lookup.Cached = s.isCached(hash)
+
+	s.logger.Debug("Looked up hash", zap.String("hash", hash), zap.Int("copies", len(files)))
+	return lookup, nil
+}
+
+// forwardLookup sends a hash lookup to the neighbours that have not run it yet
+// and collects the copies they found within the request deadline
+func (s *Service) forwardLookup(ctx context.Context, req *FederatedLookupRequest) []*HashLookup {
+	targets, visited := s.forwardTargets(req.Visited)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	forwarded := &FederatedLookupRequest{
+		QueryID:  req.QueryID,
+		Hash:     req.Hash,
+		HopsLeft: req.HopsLeft - 1,
+		Visited:  visited,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		lookups []*HashLookup
+	)
+	for _, node := range targets {
+		wg.Add(1)
+		go func(node *FederationNode) {
+			defer wg.Done()
+
+			var resp FederatedLookupResponse
+			if err := s.postFederation(ctx, node.URL+"/api/federation/lookup", forwarded, &resp); err != nil {
+				s.logger.Debug("Federated hash lookup failed", zap.String("server_id", node.ServerID), zap.Error(err))
+				return
+			}
+			if resp.Lookup == nil || !strings.EqualFold(resp.Lookup.Hash, req.Hash) {
+				return
+			}
+			for i := range resp.Lookup.Owners {
+				if resp.Lookup.Owners[i].Origin == "" {
+					resp.Lookup.Owners[i].Origin = node.ServerID
+				}
+			}
+
+			mu.Lock()
+			lookups = append(lookups, resp.Lookup)
+			mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+
+	return lookups
+}
+
+// mergeHashLookups adds the copies found by another server to a lookup, skipping
+// copies already present from the same origin. Either lookup may be nil.
+func mergeHashLookups(lookup, remote *HashLookup) *HashLookup {
+	if remote == nil {
+		return lookup
+	}
+	if lookup == nil {
+		return remote
+	}
+
+	seen := make(map[string]struct{}, len(lookup.Owners)+len(remote.Owners))
+	for _, owner := range lookup.Owners {
+		seen[owner.Origin+"/"+owner.FileID] = struct{}{}
+	}
+	for _, owner := range remote.Owners {
+		key := owner.Origin + "/" + owner.FileID
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		lookup.Owners = append(lookup.Owners, owner)
+	}
+
+	named := make(map[string]bool, len(lookup.Names))
+	for _, name := range lookup.Names {
+		named[name] = true
+	}
+	for _, name := range remote.Names {
+		if !named[name] {
+			named[name] = true
+			lookup.Names = append(lookup.Names, name)
+		}
+	}
+
+	// Every server counts the receipts its own peers filed
+	lookup.Downloads += remote.Downloads
+	lookup.Cached = lookup.Cached || remote.Cached
+	return lookup
+}
@@ -0,0 +1,131 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/inventor7/p2p/internal/db"
+	"github.com/inventor7/p2p/internal/transfer"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidMerkleTree is returned when an announced Merkle root does not match its chunk hashes
+	ErrInvalidMerkleTree = errors.New("invalid merkle tree")
+	// ErrNoMerkleTree is returned for proof requests on files shared without a Merkle root
+	ErrNoMerkleTree = errors.New("file has no merkle tree")
+	// ErrChunkOutOfRange is returned for proof requests past the end of a file
+	ErrChunkOutOfRange = errors.New("chunk index out of range")
+	// ErrFileNotFound is returned for unknown file IDs
+	ErrFileNotFound = errors.New("file not found")
+)
+
+// ChunkProof lets a downloader verify a single chunk against the file's Merkle root
+type ChunkProof struct {
+	FileID        string               `json:"file_id"`
+	HashAlgorithm string               `json:"hash_algorithm"`
+	MerkleRoot    string               `json:"merkle_root"`
+	ChunkSize     int64                `json:"chunk_size"`
+	ChunkCount    int                  `json:"chunk_count"` // Leaves in the tree, needed to check the proof's path
+	Chunk         int                  `json:"chunk"`
+	ChunkHash     string               `json:"chunk_hash"`
+	Proof         []transfer.ProofStep `json:"proof"`
+}
+
+// Bounds on the Merkle trees peers announce. Every chunk hash is stored with the
+// file, so tiny chunks would let a peer store one hash per byte of a large file.
+const (
+	minMerkleChunkSize int64 = 64 << 10 // 64KB
+	maxMerkleChunks          = 1 << 20
+)
+
+// validateMerkleTree checks the content addressing a file was announced with.
+// Files without a Merkle root are accepted as before.
+func validateMerkleTree(file *db.File, chunkHashes []string) error {
+	if file.MerkleRoot == "" {
+		if file.HashAlgorithm != "" || len(chunkHashes) > 0 {
+			return fmt.Errorf("%w: merkle_root is required with hash_algorithm and chunk_hashes", ErrInvalidMerkleTree)
+		}
+		return nil
+	}
+
+	if file.HashAlgorithm == "" {
+		file.HashAlgorithm = transfer.HashSHA256
+	}
+	file.HashAlgorithm = strings.ToLower(file.HashAlgorithm)
+	if _, err := transfer.NewHash(file.HashAlgorithm); err != nil {
+		return fmt.Errorf("%w: %v (supported: %s)", ErrInvalidMerkleTree, err, strings.Join(transfer.SupportedAlgorithms(), ", "))
+	}
+	if file.ChunkSize < minMerkleChunkSize || file.ChunkSize > transfer.MaxChunkSize {
+		return fmt.Errorf("%w: chunk_size must be between %d and %d", ErrInvalidMerkleTree, minMerkleChunkSize, transfer.MaxChunkSize)
+	}
+	expected := (file.Size + file.ChunkSize - 1) / file.ChunkSize
+	if expected > maxMerkleChunks {
+		return fmt.Errorf("%w: %d chunks exceed the limit of %d, use a larger chunk_size", ErrInvalidMerkleTree, expected, maxMerkleChunks)
+	}
+	if int64(len(chunkHashes)) != expected {
+		return fmt.Errorf("%w: %d chunk hashes for %d chunks", ErrInvalidMerkleTree, len(chunkHashes), expected)
+	}
+
+	tree, err := transfer.BuildMerkleTreeHex(file.HashAlgorithm, chunkHashes)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMerkleTree, err)
+	}
+	if !strings.EqualFold(tree.Root(), file.MerkleRoot) {
+		return fmt.Errorf("%w: chunk hashes do not produce merkle_root", ErrInvalidMerkleTree)
+	}
+	file.MerkleRoot = strings.ToLower(file.MerkleRoot)
+	return nil
+}
+
+// GetChunkProof returns the Merkle proof for one chunk of a shared file
+func (s *Service) GetChunkProof(ctx context.Context, fileID string, chunk int) (*ChunkProof, error) {
+	var file db.File
+	if err := s.db.GetDB().Where("id = ?", fileID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to load file: %w", err)
+	}
+	if file.MerkleRoot == "" {
+		return nil, ErrNoMerkleTree
+	}
+
+	var stored db.FileMerkleTree
+	if err := s.db.GetDB().Where("file_id = ?", fileID).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoMerkleTree
+		}
+		return nil, fmt.Errorf("failed to load merkle tree: %w", err)
+	}
+
+	var leaves []string
+	if stored.Leaves != "" {
+		leaves = strings.Split(stored.Leaves, ",")
+	}
+	if chunk < 0 || chunk >= len(leaves) {
+		return nil, ErrChunkOutOfRange
+	}
+
+	tree, err := transfer.BuildMerkleTreeHex(file.HashAlgorithm, leaves)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild merkle tree: %w", err)
+	}
+	proof, err := tree.Proof(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build proof: %w", err)
+	}
+
+	return &ChunkProof{
+		FileID:        file.ID,
+		HashAlgorithm: file.HashAlgorithm,
+		MerkleRoot:    file.MerkleRoot,
+		ChunkSize:     file.ChunkSize,
+		ChunkCount:    len(leaves),
+		Chunk:         chunk,
+		ChunkHash:     leaves[chunk],
+		Proof:         proof,
+	}, nil
+}
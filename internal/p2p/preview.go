@@ -0,0 +1,171 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrPreviewTooLarge is returned when an uploaded preview exceeds MaxPreviewSize
+	ErrPreviewTooLarge = errors.New("preview too large")
+	// ErrUnsupportedPreviewType is returned when a preview's content is not an allowed type
+	ErrUnsupportedPreviewType = errors.New("unsupported preview type")
+	// ErrNoPreview is returned when a file has no preview stored
+	ErrNoPreview = errors.New("file has no preview")
+)
+
+// SetPreview stores a preview (thumbnail, first page, text excerpt) for one of the
+// peer's shared files so UIs can render it without contacting the owner. The type
+// is detected from the content itself rather than trusted from the upload.
+func (s *Service) SetPreview(ctx context.Context, peerID, fileID string, r io.Reader) (*db.File, error) {
+	file, err := s.ownedFile(peerID, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, s.cfg.MaxPreviewSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preview: %w", err)
+	}
+	if int64(len(data)) > s.cfg.MaxPreviewSize {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrPreviewTooLarge, s.cfg.MaxPreviewSize)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: preview is empty", ErrUnsupportedPreviewType)
+	}
+	previewType := normalizeMediaType(http.DetectContentType(data))
+	if !s.previewTypeAllowed(previewType) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedPreviewType, previewType)
+	}
+
+	if err := os.MkdirAll(s.cfg.PreviewDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create preview directory: %w", err)
+	}
+	// Written to a temporary file first so a preview being served is never half-written
+	tmp, err := os.CreateTemp(s.cfg.PreviewDir, file.ID+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to store preview: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to store preview: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to store preview: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.previewPath(file.ID)); err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to store preview: %w", err)
+	}
+
+	file.PreviewURL = s.previewURL(file.ID)
+	if err := s.db.GetDB().Model(&db.File{ID: file.ID}).Update("preview_url", file.PreviewURL).Error; err != nil {
+		return nil, fmt.Errorf("failed to save preview url: %w", err)
+	}
+
+	s.cacheSharedFile(peerID, file)
+	s.events.Publish(EventFileUpdated, peerID, file)
+
+	s.logger.Info("Stored file preview",
+		zap.String("peer_id", peerID),
+		zap.String("file_id", file.ID),
+		zap.String("type", previewType),
+		zap.Int("size", len(data)))
+	return file, nil
+}
+
+// DeletePreview removes the preview of one of the peer's shared files
+func (s *Service) DeletePreview(ctx context.Context, peerID, fileID string) error {
+	file, err := s.ownedFile(peerID, fileID)
+	if err != nil {
+		return err
+	}
+	if file.PreviewURL == "" {
+		return ErrNoPreview
+	}
+
+	file.PreviewURL = ""
+	if err := s.db.GetDB().Model(&db.File{ID: file.ID}).Update("preview_url", "").Error; err != nil {
+		return fmt.Errorf("failed to clear preview url: %w", err)
+	}
+	s.removePreviews(file.ID)
+
+	s.cacheSharedFile(peerID, file)
+	s.events.Publish(EventFileUpdated, peerID, file)
+	return nil
+}
+
+// PreviewFile returns the path of a file's stored preview
+func (s *Service) PreviewFile(ctx context.Context, fileID string) (string, error) {
+	var file db.File
+	if err := s.db.GetDB().Select("id", "preview_url").Where("id = ?", fileID).Limit(1).Find(&file).Error; err != nil {
+		return "", fmt.Errorf("failed to look up file: %w", err)
+	}
+	if file.ID == "" {
+		return "", ErrFileNotFound
+	}
+	if file.PreviewURL == "" {
+		return "", ErrNoPreview
+	}
+
+	path := s.previewPath(file.ID)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoPreview
+		}
+		return "", fmt.Errorf("failed to open preview: %w", err)
+	}
+	return path, nil
+}
+
+// removePreviews deletes the stored previews of files that were unshared or whose
+// content changed
+func (s *Service) removePreviews(fileIDs ...string) {
+	for _, id := range fileIDs {
+		if err := os.Remove(s.previewPath(id)); err != nil && !os.IsNotExist(err) {
+			s.logger.Error("Failed to remove preview", zap.Error(err), zap.String("file_id", id))
+		}
+	}
+}
+
+// previewPath is where a file's preview is kept. File IDs are server-issued UUIDs,
+// so they are safe to use as file names.
+func (s *Service) previewPath(fileID string) string {
+	return filepath.Join(s.cfg.PreviewDir, filepath.Base(fileID))
+}
+
+// previewURL is the address UIs fetch a file's preview from
+func (s *Service) previewURL(fileID string) string {
+	return strings.TrimSuffix(s.cfg.PublicURL, "/") + "/api/p2p/files/" + fileID + "/preview"
+}
+
+// previewTypeAllowed reports whether a detected preview type may be stored
+func (s *Service) previewTypeAllowed(previewType string) bool {
+	for _, allowed := range s.cfg.AllowedPreviewTypes {
+		if strings.EqualFold(strings.TrimSpace(allowed), previewType) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxPreviewSize returns the largest preview in bytes that can be uploaded
+func (s *Service) MaxPreviewSize() int64 {
+	return s.cfg.MaxPreviewSize
+}
+
+// AllowedPreviewTypes returns the MIME types previews may have
+func (s *Service) AllowedPreviewTypes() []string {
+	return s.cfg.AllowedPreviewTypes
+}
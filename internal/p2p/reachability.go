@@ -0,0 +1,91 @@
+package p2p
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Connectivity classes assigned to peers by reachability probing
+const (
+	ConnectivityUnknown     = "unknown"     // Not probed yet
+	ConnectivityPublic      = "public"      // Accepts inbound connections on its advertised endpoint
+	ConnectivityNAT         = "nat"         // Behind a NAT; needs hole punching or a relay
+	ConnectivityUnreachable = "unreachable" // Could not be reached and no NAT was detected
+)
+
+// reachable reports whether other peers can connect to this one directly. A probe
+// result takes precedence over what the peer reported about itself.
+func (p *PeerConnection) reachable() bool {
+	if p.Connectivity == "" || p.Connectivity == ConnectivityUnknown {
+		return p.Reachable
+	}
+	return p.Connectivity == ConnectivityPublic
+}
+
+// needsProbe reports whether a peer's connectivity should be (re)checked.
+// Must be called with the service lock held.
+func (s *Service) needsProbe(conn *PeerConnection) bool {
+	if !s.cfg.ReachabilityProbeEnabled || conn.ListenPort <= 0 {
+		return false
+	}
+	if conn.LastProbe.IsZero() {
+		return true
+	}
+	recheck := time.Duration(s.cfg.ReachabilityRecheckInterval) * time.Second
+	return recheck > 0 && time.Since(conn.LastProbe) > recheck
+}
+
+// probePeer dials a peer's advertised endpoint and records how it can be reached
+func (s *Service) probePeer(conn *PeerConnection) {
+	s.mu.Lock()
+	peerID := conn.User.ID
+	ipAddress, listenPort, localIP := conn.IPAddress, conn.ListenPort, conn.LocalIP
+	conn.LastProbe = time.Now() // Claimed up front so overlapping heartbeats do not probe twice
+	s.mu.Unlock()
+
+	address := net.JoinHostPort(ipAddress, strconv.Itoa(listenPort))
+	timeout := time.Duration(s.cfg.ReachabilityProbeTimeout) * time.Second
+
+	connectivity := ConnectivityPublic
+	started := time.Now()
+	probe, err := net.DialTimeout("tcp", address, timeout)
+	rtt := time.Since(started)
+	if err == nil {
+		probe.Close()
+	} else if localIP != "" && localIP != ipAddress {
+		// The peer sees itself under a different address than we do, so there is a NAT in between
+		connectivity = ConnectivityNAT
+	} else {
+		connectivity = ConnectivityUnreachable
+	}
+
+	s.mu.Lock()
+	current := s.peers[peerID]
+	if current == nil {
+		current = s.superPeers[peerID]
+	}
+	if current != conn || conn.IPAddress != ipAddress || conn.ListenPort != listenPort {
+		// The peer left or moved while we were probing; the result is stale
+		s.mu.Unlock()
+		return
+	}
+	changed := conn.Connectivity != connectivity
+	conn.Connectivity = connectivity
+	if err == nil {
+		conn.ProbeRTT = rtt
+	}
+	dto := peerDTO(conn)
+	s.mu.Unlock()
+
+	s.logger.Debug("Probed peer reachability",
+		zap.String("peer_id", peerID),
+		zap.String("address", address),
+		zap.String("connectivity", connectivity))
+
+	if changed {
+		s.events.Publish(EventPeerReachability, peerID, dto)
+	}
+}
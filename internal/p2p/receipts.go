@@ -0,0 +1,256 @@
+package p2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+	"github.com/inventor7/p2p/internal/index"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidPublicKey is returned when a peer joins with a malformed signing key
+	ErrInvalidPublicKey = errors.New("invalid public key")
+	// ErrInvalidReceipt is returned for receipts with missing or inconsistent fields
+	ErrInvalidReceipt = errors.New("invalid transfer receipt")
+	// ErrInvalidSignature is returned when a receipt signature does not verify
+	ErrInvalidSignature = errors.New("invalid receipt signature")
+	// ErrDuplicateReceipt is returned when a receipt ID was already submitted
+	ErrDuplicateReceipt = errors.New("receipt already submitted")
+)
+
+// TransferReceipt is a downloader's signed statement that a transfer took place.
+// The uploader may countersign the same payload, which marks the receipt as confirmed.
+type TransferReceipt struct {
+	ID                string // Chosen by the downloader, unique per transfer
+	DownloaderID      string
+	UploaderID        string
+	FileHash          string
+	Bytes             int64
+	Duration          time.Duration
+	CompletedAt       time.Time
+	Signature         string // Downloader's ed25519 signature over Payload, base64
+	UploaderSignature string // Optional countersignature by the uploader, base64
+}
+
+// Payload returns the bytes both parties sign for a receipt
+func (r *TransferReceipt) Payload() []byte {
+	return []byte(strings.Join([]string{
+		"peermili-receipt-v1",
+		r.ID,
+		r.DownloaderID,
+		r.UploaderID,
+		r.FileHash,
+		strconv.FormatInt(r.Bytes, 10),
+		strconv.FormatInt(r.Duration.Milliseconds(), 10),
+		r.CompletedAt.UTC().Format(time.RFC3339),
+	}, "\n"))
+}
+
+// PeerTransferStats are a peer's aggregated transfer totals
+type PeerTransferStats struct {
+	db.PeerStats
+	Ratio float64 `json:"ratio"` // Bytes uploaded per byte downloaded
+}
+
+// parsePublicKey decodes a base64 ed25519 public key
+func parsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// verifySignature checks a base64 signature by the given peer over a payload
+func (s *Service) verifySignature(peerID, signature string, payload []byte) error {
+	var user db.User
+	if err := s.db.GetDB().Select("id", "public_key").Where("id = ?", peerID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: unknown peer %s", ErrInvalidReceipt, peerID)
+		}
+		return fmt.Errorf("failed to load peer key: %w", err)
+	}
+	if user.PublicKey == "" {
+		return fmt.Errorf("%w: peer %s has not registered a public key", ErrInvalidSignature, peerID)
+	}
+
+	key, err := parsePublicKey(user.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse stored key: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(key, payload, sig) {
+		return fmt.Errorf("%w: signature by %s does not verify", ErrInvalidSignature, peerID)
+	}
+	return nil
+}
+
+// SubmitReceipt records a signed transfer receipt. Only receipts the uploader
+// countersigned count towards the file and peer statistics, since a downloader
+// alone could claim any transfer.
+func (s *Service) SubmitReceipt(ctx context.Context, receipt *TransferReceipt) (*db.TransferReceipt, error) {
+	switch {
+	case receipt.ID == "" || receipt.UploaderID == "" || receipt.FileHash == "":
+		return nil, fmt.Errorf("%w: receipt_id, uploader_id and file_hash are required", ErrInvalidReceipt)
+	case receipt.UploaderID == receipt.DownloaderID:
+		return nil, fmt.Errorf("%w: a peer cannot download from itself", ErrInvalidReceipt)
+	case receipt.Bytes <= 0 || receipt.Duration < 0:
+		return nil, fmt.Errorf("%w: bytes must be positive", ErrInvalidReceipt)
+	case receipt.CompletedAt.After(time.Now().Add(5 * time.Minute)):
+		return nil, fmt.Errorf("%w: completed_at is in the future", ErrInvalidReceipt)
+	}
+
+	var known int64
+	err := s.db.GetDB().Model(&db.File{}).
+		Where("hash = ? AND owner_id = ?", receipt.FileHash, receipt.UploaderID).
+		Count(&known).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up file: %w", err)
+	}
+	if known == 0 {
+		return nil, fmt.Errorf("%w: peer %s does not share a file with hash %s", ErrInvalidReceipt, receipt.UploaderID, receipt.FileHash)
+	}
+
+	payload := receipt.Payload()
+	if err := s.verifySignature(receipt.DownloaderID, receipt.Signature, payload); err != nil {
+		return nil, err
+	}
+	confirmed := false
+	if receipt.UploaderSignature != "" {
+		if err := s.verifySignature(receipt.UploaderID, receipt.UploaderSignature, payload); err != nil {
+			return nil, err
+		}
+		confirmed = true
+	}
+
+	record := &db.TransferReceipt{
+		ID:           receipt.ID,
+		DownloaderID: receipt.DownloaderID,
+		UploaderID:   receipt.UploaderID,
+		FileHash:     receipt.FileHash,
+		Bytes:        receipt.Bytes,
+		DurationMs:   receipt.Duration.Milliseconds(),
+		Confirmed:    confirmed,
+		CompletedAt:  receipt.CompletedAt,
+	}
+
+	err = s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Inserting is what checks for duplicates, so concurrent submissions of the
+		// same receipt cannot both get through
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if created.Error != nil {
+			return fmt.Errorf("failed to save receipt: %w", created.Error)
+		}
+		if created.RowsAffected == 0 {
+			return ErrDuplicateReceipt
+		}
+		if !record.Confirmed {
+			return nil
+		}
+
+		fileStats := db.FileStats{Hash: record.FileHash, Downloads: 1, BytesTransferred: record.Bytes, LastDownloadAt: record.CompletedAt}
+		err := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"downloads":         gorm.Expr("downloads + 1"),
+				"bytes_transferred": gorm.Expr("bytes_transferred + ?", record.Bytes),
+				"last_download_at":  record.CompletedAt,
+			}),
+		}).Create(&fileStats).Error
+		if err != nil {
+			return fmt.Errorf("failed to update file stats: %w", err)
+		}
+
+		uploader := db.PeerStats{PeerID: record.UploaderID, Uploads: 1, BytesUploaded: record.Bytes}
+		err = tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"uploads":        gorm.Expr("uploads + 1"),
+				"bytes_uploaded": gorm.Expr("bytes_uploaded + ?", record.Bytes),
+			}),
+		}).Create(&uploader).Error
+		if err != nil {
+			return fmt.Errorf("failed to update uploader stats: %w", err)
+		}
+
+		downloader := db.PeerStats{PeerID: record.DownloaderID, Downloads: 1, BytesDownloaded: record.Bytes}
+		err = tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"downloads":        gorm.Expr("downloads + 1"),
+				"bytes_downloaded": gorm.Expr("bytes_downloaded + ?", record.Bytes),
+			}),
+		}).Create(&downloader).Error
+		if err != nil {
+			return fmt.Errorf("failed to update downloader stats: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordDemand(demandDownload, record.FileHash)
+	s.logger.Info("Recorded transfer receipt",
+		zap.String("receipt_id", record.ID),
+		zap.String("downloader_id", record.DownloaderID),
+		zap.String("uploader_id", record.UploaderID),
+		zap.Int64("bytes", record.Bytes),
+		zap.Bool("confirmed", confirmed))
+	return record, nil
+}
+
+// GetFileStats returns the download statistics of a file hash
+func (s *Service) GetFileStats(ctx context.Context, hash string) (*db.FileStats, error) {
+	stats := &db.FileStats{Hash: hash}
+	if err := s.db.GetDB().Where("hash = ?", hash).Limit(1).Find(stats).Error; err != nil {
+		return nil, fmt.Errorf("failed to load file stats: %w", err)
+	}
+	return stats, nil
+}
+
+// GetPeerStats returns a peer's upload and download totals
+func (s *Service) GetPeerStats(ctx context.Context, peerID string) (*PeerTransferStats, error) {
+	stats := &PeerTransferStats{PeerStats: db.PeerStats{PeerID: peerID}}
+	if err := s.db.GetDB().Where("peer_id = ?", peerID).Limit(1).Find(&stats.PeerStats).Error; err != nil {
+		return nil, fmt.Errorf("failed to load peer stats: %w", err)
+	}
+	if stats.BytesDownloaded > 0 {
+		stats.Ratio = float64(stats.BytesUploaded) / float64(stats.BytesDownloaded)
+	}
+	return stats, nil
+}
+
+// PopularFiles returns the most downloaded file hashes
+func (s *Service) PopularFiles(ctx context.Context, limit int) ([]*db.FileStats, error) {
+	var stats []*db.FileStats
+	if err := s.db.GetDB().Order("downloads DESC").Limit(limit).Find(&stats).Error; err != nil {
+		return nil, fmt.Errorf("failed to load popular files: %w", err)
+	}
+	return stats, nil
+}
+
+// downloadCounts returns the number of downloads recorded for each of the given hashes
+func (s *Service) downloadCounts(hashes []string) map[string]int64 {
+	counts := make(map[string]int64)
+	if len(hashes) == 0 {
+		return counts
+	}
+
+	stats, err := index.FindIn[db.FileStats](s.db.GetDB(), "hash", hashes)
+	if err != nil {
+		s.logger.Warn("Failed to load download counts", zap.Error(err))
+		return counts
+	}
+	for _, st := range stats {
+		counts[st.Hash] = st.Downloads
+	}
+	return counts
+}
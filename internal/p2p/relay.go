@@ -0,0 +1,390 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrRelayDisabled is returned when relaying is requested but not enabled on this server
+var ErrRelayDisabled = errors.New("relay service is disabled")
+
+// relayChunkSize bounds how much is copied between rate-limit checks
+const relayChunkSize = 32 * 1024
+
+// RelaySession pairs two peers whose connection is spliced through this server.
+// Both sides dial the relay address and send "RELAY <token> <peer_id>\n"; once the
+// second side arrives the server answers "OK\n" to both and forwards bytes between them.
+type RelaySession struct {
+	Token       string    `json:"token,omitempty"`
+	RequesterID string    `json:"requester_id"`
+	OwnerID     string    `json:"owner_id"`
+	FileID      string    `json:"file_id,omitempty"`
+	Address     string    `json:"address"` // host:port of the relay listener
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+
+	waiting map[string]net.Conn // Sides that connected and wait for their counterpart
+	active  bool
+}
+
+// announcement is the session as published on the event stream, which anyone can
+// follow. It leaves out the token: whoever presents it first takes over a side of
+// the relay, so the peers only receive it through authenticated calls.
+func (r *RelaySession) announcement() *RelaySession {
+	return &RelaySession{
+		RequesterID: r.RequesterID,
+		OwnerID:     r.OwnerID,
+		FileID:      r.FileID,
+		Address:     r.Address,
+		CreatedAt:   r.CreatedAt,
+		ExpiresAt:   r.ExpiresAt,
+	}
+}
+
+// RelayUsage accounts the traffic a peer pushed through the relay
+type RelayUsage struct {
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+	Sessions      int64 `json:"sessions"`
+}
+
+// relayState holds the relay listener and its sessions
+type relayState struct {
+	listener net.Listener
+	sessions map[string]*RelaySession // Keyed by token
+	usage    map[string]*RelayUsage   // Keyed by peer ID
+	limiters map[string]*rateLimiter  // Keyed by peer ID
+	mu       sync.Mutex
+}
+
+func newRelayState() *relayState {
+	return &relayState{
+		sessions: make(map[string]*RelaySession),
+		usage:    make(map[string]*RelayUsage),
+		limiters: make(map[string]*rateLimiter),
+	}
+}
+
+// rateLimiter is a token bucket limiting a peer's relayed bytes per second
+type rateLimiter struct {
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// wait blocks until n bytes may be sent
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate // Allow bursts of at most one second
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+// startRelay opens the relay listener
+func (s *Service) startRelay() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.RelayPort))
+	if err != nil {
+		return fmt.Errorf("failed to start relay listener: %w", err)
+	}
+	s.relay.listener = listener
+
+	s.logger.Info("Relay service listening", zap.String("address", listener.Addr().String()))
+	go s.acceptRelayConnections(listener)
+	return nil
+}
+
+// publicHost is the host advertised to peers for the services they reach on this
+// server directly, falling back to ServerHost when no public host is configured
+func (s *Service) publicHost() string {
+	if s.cfg.RelayPublicHost != "" {
+		return s.cfg.RelayPublicHost
+	}
+	return s.cfg.ServerHost
+}
+
+// relayAddress is the endpoint peers are told to dial for relaying
+func (s *Service) relayAddress() string {
+	return net.JoinHostPort(s.publicHost(), strconv.Itoa(s.cfg.RelayPort))
+}
+
+// RequestRelay opens a relay session between a requesting peer and the peer owning
+// a file. The owner learns about it from the event stream and gets the token from
+// its next heartbeat or relay status request.
+func (s *Service) RequestRelay(ctx context.Context, requesterID, ownerID, fileID string) (*RelaySession, error) {
+	if !s.cfg.RelayEnabled {
+		return nil, ErrRelayDisabled
+	}
+	if !s.isConnected(requesterID) || !s.isConnected(ownerID) {
+		return nil, ErrPeerNotFound
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate relay token: %w", err)
+	}
+
+	now := time.Now()
+	session := &RelaySession{
+		Token:       hex.EncodeToString(secret),
+		RequesterID: requesterID,
+		OwnerID:     ownerID,
+		FileID:      fileID,
+		Address:     s.relayAddress(),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Duration(s.cfg.RelaySessionTimeout) * time.Second),
+		waiting:     make(map[string]net.Conn),
+	}
+
+	s.relay.mu.Lock()
+	s.relay.sessions[session.Token] = session
+	s.relay.mu.Unlock()
+
+	time.AfterFunc(time.Until(session.ExpiresAt), func() { s.expireRelaySession(session.Token) })
+
+	s.events.Publish(EventRelayRequested, ownerID, session.announcement())
+	s.logger.Info("Relay session requested",
+		zap.String("requester_id", requesterID),
+		zap.String("owner_id", ownerID),
+		zap.String("file_id", fileID))
+	return session, nil
+}
+
+// PendingRelays returns the relay sessions waiting for a peer to connect
+func (s *Service) PendingRelays(peerID string) []*RelaySession {
+	s.relay.mu.Lock()
+	defer s.relay.mu.Unlock()
+
+	pending := []*RelaySession{}
+	for _, session := range s.relay.sessions {
+		if session.active || (session.OwnerID != peerID && session.RequesterID != peerID) {
+			continue
+		}
+		if _, connected := session.waiting[peerID]; !connected {
+			pending = append(pending, session)
+		}
+	}
+	return pending
+}
+
+// RelayUsageFor returns the relay traffic accounted to a peer
+func (s *Service) RelayUsageFor(peerID string) RelayUsage {
+	s.relay.mu.Lock()
+	defer s.relay.mu.Unlock()
+
+	if usage, ok := s.relay.usage[peerID]; ok {
+		return *usage
+	}
+	return RelayUsage{}
+}
+
+// expireRelaySession drops a session whose peers did not both show up in time
+func (s *Service) expireRelaySession(token string) {
+	s.relay.mu.Lock()
+	session, ok := s.relay.sessions[token]
+	if !ok || session.active {
+		s.relay.mu.Unlock()
+		return
+	}
+	delete(s.relay.sessions, token)
+	waiting := session.waiting
+	session.waiting = nil
+	s.relay.mu.Unlock()
+
+	for _, conn := range waiting {
+		conn.Close()
+	}
+	s.logger.Debug("Relay session expired before both peers connected", zap.String("owner_id", session.OwnerID))
+}
+
+// acceptRelayConnections serves the relay listener until it is closed
+func (s *Service) acceptRelayConnections(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("Failed to accept relay connection", zap.Error(err))
+			continue
+		}
+		go s.handleRelayConnection(conn)
+	}
+}
+
+// handleRelayConnection reads a peer's handshake and splices it with its
+// counterpart once both sides of the session are connected
+func (s *Service) handleRelayConnection(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != "RELAY" {
+		fmt.Fprint(conn, "ERR malformed handshake\n")
+		conn.Close()
+		return
+	}
+	token, peerID := fields[1], fields[2]
+
+	s.relay.mu.Lock()
+	session, ok := s.relay.sessions[token]
+	if !ok || session.active || (peerID != session.OwnerID && peerID != session.RequesterID) {
+		s.relay.mu.Unlock()
+		fmt.Fprint(conn, "ERR unknown session\n")
+		conn.Close()
+		return
+	}
+	if previous, exists := session.waiting[peerID]; exists {
+		previous.Close()
+	}
+	// Anything the peer sent after the handshake is still buffered in the reader
+	session.waiting[peerID] = &bufferedConn{Conn: conn, reader: reader}
+
+	requester, haveRequester := session.waiting[session.RequesterID]
+	owner, haveOwner := session.waiting[session.OwnerID]
+	if !haveRequester || !haveOwner {
+		s.relay.mu.Unlock()
+		return
+	}
+	session.active = true
+	delete(s.relay.sessions, token)
+	for _, id := range []string{session.RequesterID, session.OwnerID} {
+		if _, ok := s.relay.usage[id]; !ok {
+			s.relay.usage[id] = &RelayUsage{}
+		}
+		s.relay.usage[id].Sessions++
+	}
+	s.relay.mu.Unlock()
+
+	s.spliceRelay(session, requester, owner)
+}
+
+// bufferedConn is a connection whose first bytes were consumed by a buffered reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// spliceRelay forwards bytes in both directions until either side closes
+func (s *Service) spliceRelay(session *RelaySession, requester, owner net.Conn) {
+	for _, conn := range []net.Conn{requester, owner} {
+		if _, err := fmt.Fprint(conn, "OK\n"); err != nil {
+			requester.Close()
+			owner.Close()
+			return
+		}
+	}
+
+	s.logger.Info("Relay session started",
+		zap.String("requester_id", session.RequesterID),
+		zap.String("owner_id", session.OwnerID))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.relayCopy(requester, owner, session.OwnerID, session.RequesterID)
+	}()
+	go func() {
+		defer wg.Done()
+		s.relayCopy(owner, requester, session.RequesterID, session.OwnerID)
+	}()
+	wg.Wait()
+
+	s.logger.Info("Relay session finished",
+		zap.String("requester_id", session.RequesterID),
+		zap.String("owner_id", session.OwnerID))
+}
+
+// relayCopy copies from src (sent by fromID) to dst (received by toID), applying
+// the sender's bandwidth cap and accounting the bytes to both peers. Both
+// connections are closed when the copy ends so the opposite direction stops too.
+func (s *Service) relayCopy(dst, src net.Conn, fromID, toID string) {
+	defer dst.Close()
+	defer src.Close()
+
+	limiter := s.relayLimiter(fromID)
+	buf := make([]byte, relayChunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if limiter != nil {
+				limiter.wait(n)
+			}
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				return
+			}
+			s.accountRelay(fromID, toID, int64(n))
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logger.Debug("Relay copy ended", zap.Error(err), zap.String("from", fromID))
+			}
+			return
+		}
+	}
+}
+
+// relayLimiter returns the shared limiter for a peer, or nil when relaying is unlimited
+func (s *Service) relayLimiter(peerID string) *rateLimiter {
+	if s.cfg.RelayBandwidthLimit <= 0 {
+		return nil
+	}
+
+	s.relay.mu.Lock()
+	defer s.relay.mu.Unlock()
+
+	limiter, ok := s.relay.limiters[peerID]
+	if !ok {
+		rate := float64(s.cfg.RelayBandwidthLimit)
+		limiter = &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+		s.relay.limiters[peerID] = limiter
+	}
+	return limiter
+}
+
+// accountRelay records relayed bytes for the sending and receiving peer
+func (s *Service) accountRelay(fromID, toID string, n int64) {
+	s.relay.mu.Lock()
+	defer s.relay.mu.Unlock()
+
+	for _, id := range []string{fromID, toID} {
+		if _, ok := s.relay.usage[id]; !ok {
+			s.relay.usage[id] = &RelayUsage{}
+		}
+	}
+	s.relay.usage[fromID].BytesSent += n
+	s.relay.usage[toID].BytesReceived += n
+}
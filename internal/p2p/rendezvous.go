@@ -0,0 +1,369 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrRendezvousDisabled is returned when hole punching is requested but the rendezvous service is off
+	ErrRendezvousDisabled = errors.New("rendezvous service is disabled")
+	// ErrPunchSessionNotFound is returned for unknown or expired punch sessions
+	ErrPunchSessionNotFound = errors.New("punch session not found")
+)
+
+// Punch session states
+const (
+	PunchPending   = "pending"   // Waiting for a peer to register its UDP endpoint
+	PunchSignalled = "signalled" // Both peers were told to punch towards each other
+	PunchSucceeded = "succeeded"
+	PunchFailed    = "failed"
+)
+
+// PunchSession coordinates a UDP hole punch between two peers. Each peer gets a
+// one-time nonce for the session over the authenticated API and registers its UDP
+// endpoint by sending "REGISTER <peer_id> <nonce>" to the rendezvous port; the
+// server records the address the datagram arrived from, which is the peer's
+// public mapping on its NAT. Once both endpoints are known each peer receives
+// "PUNCH <session_id> <counterpart_id> <ip:port>" and starts sending to the
+// counterpart at the same time.
+type PunchSession struct {
+	ID          string            `json:"id"`
+	InitiatorID string            `json:"initiator_id"`
+	TargetID    string            `json:"target_id"`
+	Status      string            `json:"status"`
+	Endpoints   map[string]string `json:"endpoints"`         // Observed UDP endpoint per peer ID
+	Results     map[string]bool   `json:"results,omitempty"` // Outcome reported by each peer
+	Error       string            `json:"error,omitempty"`
+	Relay       *RelaySession     `json:"relay,omitempty"` // Fallback offered when punching failed
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+
+	// Only set on the copy handed to a peer of the session
+	Nonce string `json:"nonce,omitempty"`
+
+	nonces map[string]string       // Unused registration nonce per peer ID
+	addrs  map[string]*net.UDPAddr // Registered UDP endpoint per peer ID
+}
+
+// clone copies a session so it can be handed out while the original keeps changing.
+// The copy carries no nonces or relay token, so it is safe to publish.
+// Must be called with the rendezvous lock held.
+func (p *PunchSession) clone() *PunchSession {
+	c := *p
+	c.nonces = nil
+	c.addrs = nil
+	if p.Relay != nil {
+		c.Relay = p.Relay.announcement()
+	}
+	c.Endpoints = make(map[string]string, len(p.Endpoints))
+	for id, endpoint := range p.Endpoints {
+		c.Endpoints[id] = endpoint
+	}
+	c.Results = make(map[string]bool, len(p.Results))
+	for id, result := range p.Results {
+		c.Results[id] = result
+	}
+	return &c
+}
+
+// cloneFor copies a session for one of its peers, along with the nonce that peer
+// registers with if it has not used it yet and the token of the relay fallback.
+// Must be called with the rendezvous lock held.
+func (p *PunchSession) cloneFor(peerID string) *PunchSession {
+	c := p.clone()
+	c.Nonce = p.nonces[peerID]
+	c.Relay = p.Relay
+	return c
+}
+
+// rendezvousState holds the UDP socket and the punch sessions in progress
+type rendezvousState struct {
+	conn     *net.UDPConn
+	sessions map[string]*PunchSession
+	mu       sync.Mutex
+}
+
+func newRendezvousState() *rendezvousState {
+	return &rendezvousState{
+		sessions: make(map[string]*PunchSession),
+	}
+}
+
+// newPunchNonce generates the secret a peer registers its endpoint for a session with
+func newPunchNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate punch nonce: %w", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// startRendezvous opens the UDP socket peers register their endpoints with
+func (s *Service) startRendezvous() error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: s.cfg.RendezvousPort})
+	if err != nil {
+		return fmt.Errorf("failed to start rendezvous listener: %w", err)
+	}
+	s.rendezvous.conn = conn
+
+	s.logger.Info("Rendezvous service listening", zap.String("address", conn.LocalAddr().String()))
+	go s.serveRendezvous(conn)
+	return nil
+}
+
+// RendezvousAddress is the UDP endpoint peers register with before punching
+func (s *Service) RendezvousAddress() string {
+	return net.JoinHostPort(s.publicHost(), strconv.Itoa(s.cfg.RendezvousPort))
+}
+
+// serveRendezvous reads registrations until the socket is closed
+func (s *Service) serveRendezvous(conn *net.UDPConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("Failed to read rendezvous datagram", zap.Error(err))
+			continue
+		}
+
+		fields := strings.Fields(string(buf[:n]))
+		if len(fields) != 3 || fields[0] != "REGISTER" {
+			continue
+		}
+		session, ok := s.registerEndpoint(fields[1], fields[2], addr)
+		if !ok {
+			conn.WriteToUDP([]byte("ERR invalid nonce\n"), addr)
+			continue
+		}
+		// Tell the peer how it is seen from outside its NAT
+		conn.WriteToUDP([]byte("OK "+addr.String()+"\n"), addr)
+		if session != nil {
+			s.signalPunch(session)
+		}
+	}
+}
+
+// registerEndpoint records a peer's UDP endpoint for the pending session its nonce
+// was issued for, using up the nonce. It reports whether the nonce was valid and
+// returns the session when it now has both endpoints and is ready to be signalled.
+func (s *Service) registerEndpoint(peerID, nonce string, addr *net.UDPAddr) (*PunchSession, bool) {
+	s.rendezvous.mu.Lock()
+	defer s.rendezvous.mu.Unlock()
+
+	for _, session := range s.rendezvous.sessions {
+		expected, ok := session.nonces[peerID]
+		if !ok || session.Status != PunchPending || subtle.ConstantTimeCompare([]byte(nonce), []byte(expected)) != 1 {
+			continue
+		}
+		delete(session.nonces, peerID)
+		session.addrs[peerID] = addr
+		session.Endpoints[peerID] = addr.String()
+		if len(session.addrs) < 2 {
+			return nil, true
+		}
+		session.Status = PunchSignalled
+		return session, true
+	}
+	return nil, false
+}
+
+// ConnectToPeer starts a hole punch between the initiator and a target peer. The
+// initiator gets its nonce in the returned session; the target is told through
+// the event stream and fetches its own from GetPunchSession. The session waits
+// until both peers have registered their UDP endpoint.
+func (s *Service) ConnectToPeer(ctx context.Context, initiatorID, targetID string) (*PunchSession, error) {
+	if !s.cfg.RendezvousEnabled || s.rendezvous.conn == nil {
+		return nil, ErrRendezvousDisabled
+	}
+	if !s.isConnected(initiatorID) || !s.isConnected(targetID) {
+		return nil, ErrPeerNotFound
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate punch session ID: %w", err)
+	}
+	nonces := make(map[string]string, 2)
+	for _, peerID := range []string{initiatorID, targetID} {
+		nonce, err := newPunchNonce()
+		if err != nil {
+			return nil, err
+		}
+		nonces[peerID] = nonce
+	}
+
+	now := time.Now()
+	timeout := time.Duration(s.cfg.PunchTimeout) * time.Second
+	session := &PunchSession{
+		ID:          hex.EncodeToString(id),
+		InitiatorID: initiatorID,
+		TargetID:    targetID,
+		Status:      PunchPending,
+		Endpoints:   make(map[string]string),
+		Results:     make(map[string]bool),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(timeout),
+		nonces:      nonces,
+		addrs:       make(map[string]*net.UDPAddr, 2),
+	}
+
+	s.rendezvous.mu.Lock()
+	s.rendezvous.sessions[session.ID] = session
+	snapshot := session.clone()
+	result := session.cloneFor(initiatorID)
+	s.rendezvous.mu.Unlock()
+
+	time.AfterFunc(timeout, func() { s.expirePunch(session.ID) })
+
+	s.events.Publish(EventPunchRequested, targetID, snapshot)
+
+	s.logger.Info("Hole punch requested",
+		zap.String("initiator_id", initiatorID),
+		zap.String("target_id", targetID),
+		zap.String("session_id", session.ID))
+	return result, nil
+}
+
+// signalPunch sends each peer the endpoint of its counterpart. The signal goes out
+// from the rendezvous socket so it passes the NAT mapping the peer registered through.
+func (s *Service) signalPunch(session *PunchSession) {
+	s.rendezvous.mu.Lock()
+	type signal struct {
+		to      *net.UDPAddr
+		message string
+	}
+	var signals []signal
+	for _, pair := range [][2]string{
+		{session.InitiatorID, session.TargetID},
+		{session.TargetID, session.InitiatorID},
+	} {
+		to, ok := session.addrs[pair[0]]
+		if !ok {
+			continue
+		}
+		message := fmt.Sprintf("PUNCH %s %s %s\n", session.ID, pair[1], session.Endpoints[pair[1]])
+		signals = append(signals, signal{to: to, message: message})
+	}
+	snapshot := session.clone()
+	s.rendezvous.mu.Unlock()
+
+	for _, sig := range signals {
+		if _, err := s.rendezvous.conn.WriteToUDP([]byte(sig.message), sig.to); err != nil {
+			s.logger.Warn("Failed to send punch signal", zap.Error(err), zap.String("session_id", session.ID))
+		}
+	}
+	s.events.Publish(EventPunchUpdated, session.TargetID, snapshot)
+}
+
+// GetPunchSession returns a punch session to one of its peers, with the nonce the
+// peer registers its endpoint with until it has done so
+func (s *Service) GetPunchSession(sessionID, peerID string) (*PunchSession, error) {
+	s.rendezvous.mu.Lock()
+	defer s.rendezvous.mu.Unlock()
+
+	session, ok := s.rendezvous.sessions[sessionID]
+	if !ok || (session.InitiatorID != peerID && session.TargetID != peerID) {
+		return nil, ErrPunchSessionNotFound
+	}
+	return session.cloneFor(peerID), nil
+}
+
+// ReportPunchResult records whether a peer managed to reach its counterpart. The
+// session succeeds as soon as one side gets through and fails once both report
+// failure; a failed session offers a relay when the relay service is enabled.
+func (s *Service) ReportPunchResult(ctx context.Context, sessionID, peerID string, success bool, reason string) (*PunchSession, error) {
+	s.rendezvous.mu.Lock()
+	session, ok := s.rendezvous.sessions[sessionID]
+	if !ok || (session.InitiatorID != peerID && session.TargetID != peerID) {
+		s.rendezvous.mu.Unlock()
+		return nil, ErrPunchSessionNotFound
+	}
+
+	session.Results[peerID] = success
+	finished := false
+	if session.Status != PunchSucceeded && session.Status != PunchFailed {
+		if success {
+			session.Status = PunchSucceeded
+			finished = true
+		} else if len(session.Results) == 2 {
+			session.Status = PunchFailed
+			session.Error = reason
+			finished = true
+		}
+	}
+	s.rendezvous.mu.Unlock()
+
+	if finished {
+		s.finishPunch(ctx, session)
+	}
+	return s.GetPunchSession(sessionID, peerID)
+}
+
+// expirePunch fails a session the peers did not complete in time
+func (s *Service) expirePunch(sessionID string) {
+	s.rendezvous.mu.Lock()
+	session, ok := s.rendezvous.sessions[sessionID]
+	if !ok {
+		s.rendezvous.mu.Unlock()
+		return
+	}
+	finished := session.Status != PunchSucceeded && session.Status != PunchFailed
+	if finished {
+		session.Status = PunchFailed
+		session.Error = "timed out"
+	}
+	s.rendezvous.mu.Unlock()
+
+	if finished {
+		s.finishPunch(context.Background(), session)
+	}
+
+	// Keep the outcome around long enough for both peers to poll it
+	time.AfterFunc(time.Duration(s.cfg.PunchTimeout)*time.Second, func() {
+		s.rendezvous.mu.Lock()
+		delete(s.rendezvous.sessions, sessionID)
+		s.rendezvous.mu.Unlock()
+	})
+}
+
+// finishPunch sets up the relay fallback for a failed session and announces the outcome
+func (s *Service) finishPunch(ctx context.Context, session *PunchSession) {
+	s.rendezvous.mu.Lock()
+	failed := session.Status == PunchFailed
+	s.rendezvous.mu.Unlock()
+
+	if failed && s.cfg.RelayEnabled {
+		relay, err := s.RequestRelay(ctx, session.InitiatorID, session.TargetID, "")
+		if err != nil {
+			s.logger.Warn("Failed to open relay fallback", zap.Error(err), zap.String("session_id", session.ID))
+		} else {
+			s.rendezvous.mu.Lock()
+			session.Relay = relay
+			s.rendezvous.mu.Unlock()
+		}
+	}
+
+	s.rendezvous.mu.Lock()
+	snapshot := session.clone()
+	s.rendezvous.mu.Unlock()
+
+	s.events.Publish(EventPunchUpdated, session.InitiatorID, snapshot)
+	s.logger.Info("Hole punch finished",
+		zap.String("session_id", session.ID),
+		zap.String("status", snapshot.Status))
+}
@@ -0,0 +1,121 @@
+package p2p
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Weights of the factors that make up a source score; they add up to one
+const (
+	scoreWeightReachability = 0.4
+	scoreWeightLoad         = 0.25
+	scoreWeightBandwidth    = 0.2
+	scoreWeightLatency      = 0.15
+)
+
+// reachabilityScore rates each connectivity class as a download source. Peers
+// behind a NAT can still be reached through hole punching or a relay.
+var reachabilityScore = map[string]float64{
+	ConnectivityPublic:      1,
+	ConnectivityUnknown:     0.6,
+	ConnectivityNAT:         0.4,
+	ConnectivityUnreachable: 0.1,
+}
+
+// neutralScore is used for factors a peer has not reported
+const neutralScore = 0.5
+
+// effectiveConnectivity is the connectivity class a peer is ranked by: the probe
+// result if there is one, otherwise what the peer reported about itself.
+// Must be called with the service lock held.
+func (p *PeerConnection) effectiveConnectivity() string {
+	if p.Connectivity != "" && p.Connectivity != ConnectivityUnknown {
+		return p.Connectivity
+	}
+	if p.ReportedConnectivity != "" {
+		return p.ReportedConnectivity
+	}
+	if p.Reachable {
+		return ConnectivityPublic
+	}
+	return ConnectivityUnknown
+}
+
+// load returns the share of the peer's upload capacity in use, from 0 to 1.
+// Without a reported slot count every running upload counts as half the capacity.
+// Must be called with the service lock held.
+func (p *PeerConnection) load() float64 {
+	if p.ActiveUploads <= 0 {
+		return 0
+	}
+	if p.MaxUploads > 0 {
+		return math.Min(float64(p.ActiveUploads)/float64(p.MaxUploads), 1)
+	}
+	return 1 - 1/float64(1+p.ActiveUploads)
+}
+
+// latency returns the best known round trip to the peer: the probe's connect time
+// if it succeeded, otherwise the peer's own measurement.
+// Must be called with the service lock held.
+func (p *PeerConnection) latency() time.Duration {
+	if p.ProbeRTT > 0 {
+		return p.ProbeRTT
+	}
+	return p.LatencyHint
+}
+
+// sourceScore rates how good a download source the peer is right now, from 0 to 100.
+// Must be called with the service lock held.
+func (p *PeerConnection) sourceScore() float64 {
+	reachability, ok := reachabilityScore[p.effectiveConnectivity()]
+	if !ok {
+		reachability = reachabilityScore[ConnectivityUnknown]
+	}
+
+	// Saturating curves: 1 Mbps scores 0.5, 10 Mbps about 0.9; 100ms scores 0.5
+	bandwidth := neutralScore
+	if p.UploadBandwidth > 0 {
+		bandwidth = float64(p.UploadBandwidth) / float64(p.UploadBandwidth+1000)
+	}
+	latency := neutralScore
+	if rtt := p.latency(); rtt > 0 {
+		latency = 1 / (1 + float64(rtt)/float64(100*time.Millisecond))
+	}
+
+	score := scoreWeightReachability*reachability +
+		scoreWeightLoad*(1-p.load()) +
+		scoreWeightBandwidth*bandwidth +
+		scoreWeightLatency*latency
+	return math.Round(score*1000) / 10
+}
+
+// applySourceScore fills in the ranking fields of a search result from its owner's connection.
+// Must be called with the service lock held.
+func applySourceScore(result *FileSearchResult, conn *PeerConnection) {
+	result.SourceScore = conn.sourceScore()
+	result.PeerBandwidth = conn.UploadBandwidth
+	result.PeerLoad = math.Round(conn.load()*100) / 100
+	result.PeerLatencyHint = conn.latency().Milliseconds()
+}
+
+// cacheSourceScore rates the super peer's own cache, which is publicly reachable
+// but whose load and bandwidth are not measured
+func cacheSourceScore() float64 {
+	cache := &PeerConnection{Connectivity: ConnectivityPublic}
+	return cache.sourceScore()
+}
+
+// rankResults orders search results by relevance to the query, then best source
+// first, breaking ties by download count and keeping the original order otherwise
+func rankResults(results []*FileSearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Relevance != results[j].Relevance {
+			return results[i].Relevance > results[j].Relevance
+		}
+		if results[i].SourceScore != results[j].SourceScore {
+			return results[i].SourceScore > results[j].SourceScore
+		}
+		return results[i].Downloads > results[j].Downloads
+	})
+}
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -9,7 +10,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/inventor7/p2p/internal/config"
 	"github.com/inventor7/p2p/internal/db"
+	"github.com/inventor7/p2p/internal/index"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPeerNotFound is returned when an operation targets a peer that is not connected
+	ErrPeerNotFound = errors.New("peer not found")
+	// ErrFileTooLarge is returned when a shared file exceeds MaxFileSize
+	ErrFileTooLarge = errors.New("file size exceeds maximum allowed size")
 )
 
 // Service handles P2P networking and file transfer functionality
@@ -22,6 +32,33 @@ type Service struct {
 	peers      map[string]*PeerConnection
 	superPeers map[string]*PeerConnection
 	mu         sync.RWMutex
+
+	// Sessions restored from the database at startup that peers may still resume
+	resumable map[string]*db.PeerSession
+
+	// Neighbour super-peer servers
+	federation *federation
+
+	// Capacity reservations for joins in progress
+	admission admissionState
+
+	// Presence and sharing transitions for streaming clients
+	events *EventBus
+
+	// Relay sessions for peers that cannot connect to each other
+	relay *relayState
+
+	// UDP hole punching between NATed peers
+	rendezvous *rendezvousState
+
+	// Popular files seeded by this super peer; nil unless caching is enabled
+	cache *contentCache
+
+	// Full-text search over shared files, kept in sync as files change
+	search index.SearchBackend
+
+	// Callbacks run for every newly shared file; registered at startup
+	fileShared []func(ctx context.Context, files []*db.File)
 }
 
 // PeerConnection represents an active peer connection
@@ -33,27 +70,175 @@ type PeerConnection struct {
 	Files      map[string]*db.File // Local cache of shared files
 	IsActive   bool
 	Disconnect chan struct{}
+
+	Capabilities []string // Optional features advertised by the peer
+
+	// SharedFileCount is the library size last reported by the peer in a heartbeat
+	SharedFileCount int
+
+	// Reported by the peer and used to elect super peers
+	ConnectedAt     time.Time
+	ReportedUptime  time.Duration
+	UploadBandwidth int  // kbps
+	Reachable       bool // Whether the peer accepts inbound connections
+	SuperCandidate  bool // Whether the peer volunteered to act as a super peer
+
+	// Determined by probing the peer's advertised endpoint
+	LocalIP      string // Address the peer sees itself under, used to detect NAT
+	Connectivity string
+	LastProbe    time.Time
+	ProbeRTT     time.Duration // Time the last successful probe took to connect
+
+	// Reported in heartbeats and used to rank the peer as a download source
+	ActiveUploads        int
+	MaxUploads           int           // Upload slots the peer offers, zero if unknown
+	LatencyHint          time.Duration // Round trip the peer measured to the super peer
+	ReportedConnectivity string        // Used until a probe has classified the peer
+
+	// SHA-256 of the credential the peer joined with, checked on every request
+	credential [32]byte
+}
+
+// sharedFileCount returns the best known number of files shared by the peer.
+// Must be called with the service lock held.
+func (p *PeerConnection) sharedFileCount() int {
+	if p.SharedFileCount > len(p.Files) {
+		return p.SharedFileCount
+	}
+	return len(p.Files)
+}
+
+// PeerStatusUpdate carries the optional fields a peer can refresh with a heartbeat.
+// Zero values leave the current state untouched.
+type PeerStatusUpdate struct {
+	IPAddress       string
+	ListenPort      int
+	SharedFileCount *int
+	Uptime          time.Duration
+	UploadBandwidth int // kbps
+	Reachable       *bool
+	ActiveUploads   *int
+	MaxUploads      int
+	Latency         time.Duration
+	Connectivity    string // One of the Connectivity* classes, as seen by the peer
+}
+
+// PeerRegistration describes a peer announcing itself to the network.
+// PeerID and Credential are only set by a peer resuming a previously issued identity.
+// With super-peer election enabled IsSuper only volunteers the peer as a candidate.
+type PeerRegistration struct {
+	PeerName        string
+	IPAddress       string
+	ListenPort      int
+	IsSuper         bool
+	Capabilities    []string
+	PeerID          string
+	Credential      string
+	Uptime          time.Duration
+	UploadBandwidth int // kbps
+	Reachable       bool
+	LocalIP         string
+	PublicKey       string // Base64 ed25519 key used to verify the peer's transfer receipts
+}
+
+// JoinResult describes the identity a peer ended up with after joining
+type JoinResult struct {
+	User        *db.User
+	Credential  string // Only set when a new identity was issued; the peer must keep it to resume later
+	Resumed     bool
+	SharedFiles int
+	SpaceIDs    []string
+	Role        string
 }
 
 // NewService creates a new P2P service instance
-func NewService(cfg *config.Config, database *db.Database, logger *zap.Logger) *Service {
-	return &Service{
+func NewService(cfg *config.Config, database *db.Database, search index.SearchBackend, logger *zap.Logger) *Service {
+	s := &Service{
 		cfg:        cfg,
 		db:         database,
 		logger:     logger,
+		search:     search,
 		peers:      make(map[string]*PeerConnection),
 		superPeers: make(map[string]*PeerConnection),
+		resumable:  make(map[string]*db.PeerSession),
+		federation: newFederation(),
+		admission:  admissionState{slotFreed: make(chan struct{})},
+		events:     NewEventBus(1024),
+		relay:      newRelayState(),
+		rendezvous: newRendezvousState(),
+	}
+
+	// Pick up the peers that were online before the last shutdown
+	s.restoreSessions()
+
+	switch {
+	case !s.FederationEnabled():
+		logger.Warn("Federation disabled: FEDERATION_SECRET is not set")
+	case cfg.FederationSyncInterval > 0:
+		go s.runFederation()
+	}
+	if cfg.SuperPeerElection && cfg.ElectionInterval > 0 {
+		go s.runElection()
+	}
+	if cfg.RelayEnabled {
+		if err := s.startRelay(); err != nil {
+			logger.Error("Relay service unavailable", zap.Error(err))
+		}
+	}
+	if cfg.RendezvousEnabled {
+		if err := s.startRendezvous(); err != nil {
+			logger.Error("Rendezvous service unavailable", zap.Error(err))
+		}
 	}
+	if cfg.CacheEnabled {
+		if err := s.startCache(); err != nil {
+			logger.Error("Content cache unavailable", zap.Error(err))
+			s.cache = nil
+		}
+	}
+
+	return s
 }
 
-// RegisterPeer registers a new peer in the network
-func (s *Service) RegisterPeer(ctx context.Context, peerName string, ipAddress string, listenPort int, isSuper bool) (*db.User, error) {
+// RegisterPeer registers a peer in the network. A peer presenting a previously
+// issued peer ID and credential gets its old identity back; otherwise a new
+// identity is created and its credential returned once.
+func (s *Service) RegisterPeer(ctx context.Context, reg PeerRegistration) (*JoinResult, error) {
+	if reg.PublicKey != "" {
+		if _, err := parsePublicKey(reg.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+	if reg.PeerID != "" {
+		return s.rejoinPeer(ctx, reg)
+	}
+
+	// Peer names are unique; a returning peer has to present its credential instead
+	if err := s.claimPeerName(reg.PeerName, ""); err != nil {
+		return nil, err
+	}
+
+	// Hold a slot for the peer while its identity is created
+	isSuper := s.grantsSuperOnJoin(reg)
+	if err := s.acquireSlot(ctx, isSuper); err != nil {
+		return nil, err
+	}
+	joined := false
+	defer func() { s.releaseSlot(isSuper, joined) }()
+
+	credential, credentialHash, err := newPeerCredential()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create new user record
 	user := &db.User{
-		ID:       uuid.New().String(),
-		Username: peerName, // Use peerName for Username
-		IsSuper:  isSuper,
-		LastSeen: time.Now(),
+		ID:           uuid.New().String(),
+		Username:     reg.PeerName, // Use peerName for Username
+		IsSuper:      isSuper,
+		PasswordHash: credentialHash,
+		LastSeen:     time.Now(),
+		PublicKey:    reg.PublicKey,
 		// IPAddress and ListenPort are not part of db.User by default.
 		// If they need to be persisted in db.User, that model needs an update.
 		// For now, they are stored in PeerConnection.
@@ -67,75 +252,185 @@ func (s *Service) RegisterPeer(ctx context.Context, peerName string, ipAddress s
 	// Initialize peer connection
 	conn := &PeerConnection{
 		User:       user,
-		IPAddress:  ipAddress,  // Store IP
-		ListenPort: listenPort, // Store Port
+		IPAddress:  reg.IPAddress,  // Store IP
+		ListenPort: reg.ListenPort, // Store Port
 		LastPing:   time.Now(),
 		Files:      make(map[string]*db.File),
 		IsActive:   true,
 		Disconnect: make(chan struct{}),
+		credential: credentialDigest(credential),
 	}
+	applyRegistration(conn, reg)
+	s.attachConnection(conn)
+	joined = true
+
+	result := s.joinResult(conn)
+	result.Credential = credential
+	return result, nil
+}
+
+// joinResult reports the identity and role a peer joined with. An election may
+// promote or demote the peer as soon as it is attached, so the user is copied
+// under the service lock rather than shared with the connection.
+func (s *Service) joinResult(conn *PeerConnection) *JoinResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user := *conn.User
+	return &JoinResult{User: &user, Role: conn.role()}
+}
+
+// grantsSuperOnJoin decides whether a joining peer starts out as a super peer.
+// When election is enabled the role is only ever granted by an election.
+func (s *Service) grantsSuperOnJoin(reg PeerRegistration) bool {
+	return reg.IsSuper && !s.cfg.SuperPeerElection
+}
+
+// applyRegistration copies the self-reported capabilities and metrics of a joining peer
+func applyRegistration(conn *PeerConnection, reg PeerRegistration) {
+	conn.Capabilities = reg.Capabilities
+	conn.ConnectedAt = time.Now()
+	conn.ReportedUptime = reg.Uptime
+	conn.UploadBandwidth = reg.UploadBandwidth
+	conn.Reachable = reg.Reachable
+	conn.LocalIP = reg.LocalIP
+	conn.Connectivity = ConnectivityUnknown
+	conn.SuperCandidate = reg.IsSuper
+}
+
+// attachConnection makes a peer connection visible to the network, replacing any
+// connection the same peer still had, and starts monitoring its heartbeats
+func (s *Service) attachConnection(conn *PeerConnection) {
+	peerID := conn.User.ID
 
 	// Add to appropriate peer map
 	s.mu.Lock()
-	if isSuper {
-		s.superPeers[user.ID] = conn
+	delete(s.resumable, peerID)
+	for _, peerMap := range []map[string]*PeerConnection{s.peers, s.superPeers} {
+		if old, exists := peerMap[peerID]; exists {
+			close(old.Disconnect)
+			delete(peerMap, peerID)
+		}
+	}
+	if conn.User.IsSuper {
+		s.superPeers[peerID] = conn
 	} else {
-		s.peers[user.ID] = conn
+		s.peers[peerID] = conn
 	}
+	joined := peerDTO(conn)
+	probe := s.needsProbe(conn)
 	s.mu.Unlock()
 
+	s.events.Publish(EventPeerJoined, peerID, joined)
+	if probe {
+		go s.probePeer(conn)
+	}
+
+	if err := s.saveSession(conn); err != nil {
+		// The peer is online either way; it just won't survive a restart
+		s.logger.Warn("Failed to persist peer session", zap.Error(err), zap.String("peer_id", peerID))
+	}
+
 	// Start heartbeat monitoring
 	go s.monitorPeerConnection(conn)
-
-	return user, nil // Return the created user object (which includes the ID)
 }
 
-// ShareFile makes a file available for sharing
-func (s *Service) ShareFile(ctx context.Context, userID string, file *db.File) error {
-	// Validate file size and type
-	if file.Size > s.cfg.MaxFileSize {
-		return fmt.Errorf("file size exceeds maximum allowed size of %d bytes", s.cfg.MaxFileSize)
+// ShareFile makes a file available for sharing. Files announced with a Merkle root
+// carry the hash of every chunk so that proofs can be served for them.
+func (s *Service) ShareFile(ctx context.Context, userID string, file *db.File, chunkHashes []string) error {
+	if err := s.prepareFile(file, chunkHashes); err != nil {
+		return err
 	}
 
-	// Save file metadata to database
-	if err := s.db.GetDB().Create(file).Error; err != nil {
-		return fmt.Errorf("failed to save file metadata: %w", err)
+	// Save file metadata, and the chunk hashes proofs are served from, to database
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		return createFileRecord(tx, file, chunkHashes)
+	})
+	if err != nil {
+		return err
 	}
 
 	// Update peer's shared files cache
-	s.mu.Lock()
-	if peer, exists := s.peers[userID]; exists {
-		peer.Files[file.ID] = file
-	}
-	s.mu.Unlock()
+	s.cacheSharedFile(userID, file)
+	s.search.Index(file)
 
+	s.events.Publish(EventFileShared, userID, file)
+	s.notifyFileShared(ctx, file)
 	return nil
 }
 
+// prepareFile validates a file about to be shared and fills in its resolved type
+func (s *Service) prepareFile(file *db.File, chunkHashes []string) error {
+	if file.LastModified.IsZero() {
+		file.LastModified = time.Now()
+	}
+	// Validate file size and type
+	if file.Size > s.cfg.MaxFileSize {
+		return fmt.Errorf("%w: maximum allowed size is %d bytes", ErrFileTooLarge, s.cfg.MaxFileSize)
+	}
+	fileType, err := s.resolveFileType(file.Type, file.Name)
+	if err != nil {
+		return err
+	}
+	file.Type = fileType
+	return validateMerkleTree(file, chunkHashes)
+}
+
 // FileSearchResult combines file details with the peer's contact information.
 type FileSearchResult struct {
 	db.File
-	PeerIPAddress  string `json:"peer_ip_address"`
-	PeerListenPort int    `json:"peer_listen_port"`
+	PeerIPAddress    string `json:"peer_ip_address"`
+	PeerListenPort   int    `json:"peer_listen_port"`
+	PeerConnectivity string `json:"peer_connectivity"` // How the owner can be reached, see Connectivity*
+	Origin           string `json:"origin"`            // ID of the super-peer server the owner is connected to
+	Cached           bool   `json:"cached,omitempty"`  // Served from the super peer's cache while the owner is offline
+	Downloads        int64  `json:"downloads"`         // Completed downloads reported through transfer receipts
+	// How well the file matches the query; higher is better and results are ordered by it.
+	// Network searches scale it so the best match from each origin server scores 1.
+	Relevance float64 `json:"relevance"`
+	// How good a source the owner is right now, from 0 to 100; ties in relevance are ordered by it
+	SourceScore     float64 `json:"source_score"`
+	PeerBandwidth   int     `json:"peer_upload_bandwidth,omitempty"` // kbps
+	PeerLoad        float64 `json:"peer_load"`                       // Share of the owner's upload capacity in use
+	PeerLatencyHint int64   `json:"peer_latency_ms,omitempty"`
 }
 
-// SearchSharedFiles searches for globally shared files and returns them with peer contact info.
-func (s *Service) SearchSharedFiles(ctx context.Context, query string) ([]*FileSearchResult, error) {
-	var dbFiles []*db.File
+// SearchSharedFiles searches for globally shared files matching opts and returns
+// them with peer contact info. Every match is returned; paging is left to the caller.
+func (s *Service) SearchSharedFiles(ctx context.Context, opts *index.SearchOptions) ([]*FileSearchResult, error) {
+	query := opts.Query
+	q := s.db.GetDB().Model(&db.File{}).Scopes(opts.Scope)
 
-	// For MySQL, LIKE is often case-insensitive by default depending on collation.
-	// If specific case-insensitivity is needed and collation doesn't ensure it:
-	// err := s.db.GetDB().Where("LOWER(name) LIKE LOWER(?)", "%"+query+"%").Find(&dbFiles).Error
-	// For simplicity, assuming default MySQL behavior or case-insensitive collation:
-	searchTerm := "%" + query + "%"
-	if err := s.db.GetDB().Where("name LIKE ?", searchTerm).Find(&dbFiles).Error; err != nil { // <--- CHANGED ILIKE to LIKE
-		s.logger.Error("Failed to search shared files in DB", zap.Error(err), zap.String("query", query))
+	var relevance map[string]float64
+	var dbFiles []*db.File
+	if query != "" {
+		hits, err := s.search.Search(ctx, query)
+		if err != nil {
+			s.logger.Error("Failed to search shared files", zap.Error(err), zap.String("query", query))
+			return nil, fmt.Errorf("failed to search shared files: %w", err)
+		}
+		if len(hits) == 0 {
+			return nil, nil
+		}
+		ids := make([]string, len(hits))
+		relevance = make(map[string]float64, len(hits))
+		for i, hit := range hits {
+			ids[i] = hit.FileID
+			relevance[hit.FileID] = hit.Relevance
+		}
+		dbFiles, err = index.FindIn[db.File](q, "files.id", ids)
+		if err != nil {
+			s.logger.Error("Failed to load matched files from DB", zap.Error(err), zap.String("query", query))
+			return nil, fmt.Errorf("failed to search shared files: %w", err)
+		}
+	} else if err := q.Find(&dbFiles).Error; err != nil {
+		s.logger.Error("Failed to load matched files from DB", zap.Error(err), zap.String("query", query))
 		return nil, fmt.Errorf("failed to search shared files: %w", err)
 	}
 
 	var results []*FileSearchResult
-	s.mu.RLock() // Read lock for accessing peers maps
-	defer s.mu.RUnlock()
+	online := make(map[string]bool) // File hashes with at least one active owner
+	s.mu.RLock()                    // Read lock for accessing peers maps
 
 	for _, file := range dbFiles {
 		var conn *PeerConnection
@@ -150,15 +445,39 @@ func (s *Service) SearchSharedFiles(ctx context.Context, query string) ([]*FileS
 		}
 
 		if found {
-			results = append(results, &FileSearchResult{
-				File:           *file,
-				PeerIPAddress:  conn.IPAddress,
-				PeerListenPort: conn.ListenPort,
-			})
+			online[file.Hash] = true
+			result := &FileSearchResult{
+				File:             *file,
+				PeerIPAddress:    conn.IPAddress,
+				PeerListenPort:   conn.ListenPort,
+				PeerConnectivity: conn.Connectivity,
+			}
+			applySourceScore(result, conn)
+			results = append(results, result)
 		} else {
 			s.logger.Debug("File found in DB but owner peer is not active or not found in memory", zap.String("fileID", file.ID), zap.String("ownerID", file.OwnerID))
 		}
 	}
+	s.mu.RUnlock()
+
+	// Files whose owners went offline can still be fetched from the cache
+	if !opts.OnlineOnly {
+		results = append(results, s.cachedResults(dbFiles, online)...)
+	}
+	hashes := make([]string, 0, len(results))
+	for _, result := range results {
+		hashes = append(hashes, result.Hash)
+	}
+	s.recordDemand(demandSearch, hashes...)
+
+	downloads := s.downloadCounts(hashes)
+	for _, result := range results {
+		result.Downloads = downloads[result.Hash]
+		result.Relevance = relevance[result.ID]
+	}
+
+	// Best matches first, then the best sources and popular files among them
+	rankResults(results)
 
 	s.logger.Info("Searched shared files", zap.String("query", query), zap.Int("db_matches", len(dbFiles)), zap.Int("active_results", len(results)))
 	return results, nil
@@ -175,24 +494,41 @@ func (s *Service) GetPeerFiles(ctx context.Context, peerID string) ([]*db.File,
 
 // DisconnectPeer handles peer disconnection
 func (s *Service) DisconnectPeer(ctx context.Context, peerID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if !s.removePeer(peerID, nil, EventPeerLeft) {
+		return ErrPeerNotFound
+	}
+	return nil
+}
 
+// removePeer drops a peer's connection and forgets its session. When expected is
+// set the peer is only removed if that is still its current connection, so a stale
+// monitor cannot evict a peer that has since reconnected.
+func (s *Service) removePeer(peerID string, expected *PeerConnection, reason string) bool {
+	s.mu.Lock()
 	// Check super peers first
-	if peer, exists := s.superPeers[peerID]; exists {
-		close(peer.Disconnect)
+	peer, exists := s.superPeers[peerID]
+	if !exists {
+		// Check regular peers
+		peer, exists = s.peers[peerID]
+	}
+	if exists && expected != nil && peer != expected {
+		exists = false
+	}
+	if exists {
 		delete(s.superPeers, peerID)
-		return nil
+		delete(s.peers, peerID)
+		close(peer.Disconnect)
+		s.notifySlotFreed()
 	}
+	s.mu.Unlock()
 
-	// Check regular peers
-	if peer, exists := s.peers[peerID]; exists {
-		close(peer.Disconnect)
-		delete(s.peers, peerID)
-		return nil
+	if !exists {
+		return false
 	}
 
-	return fmt.Errorf("peer not found")
+	s.events.Publish(reason, peerID, nil)
+	s.deleteSession(peerID)
+	return true
 }
 
 // monitorPeerConnection monitors peer connection health
@@ -203,14 +539,18 @@ func (s *Service) monitorPeerConnection(peer *PeerConnection) {
 	for {
 		select {
 		case <-ticker.C:
+			s.mu.RLock()
+			lastPing := peer.LastPing
+			s.mu.RUnlock()
+
 			// Check if peer has exceeded timeout
-			if time.Since(peer.LastPing) > time.Duration(s.cfg.ConnectionTimeout)*time.Second {
+			if time.Since(lastPing) > time.Duration(s.cfg.ConnectionTimeout)*time.Second {
 				s.logger.Info("Peer connection timed out",
 					zap.String("peer_id", peer.User.ID),
 					zap.String("username", peer.User.Username))
 
 				// Disconnect peer
-				s.DisconnectPeer(context.Background(), peer.User.ID)
+				s.removePeer(peer.User.ID, peer, EventPeerTimedOut)
 				return
 			}
 		case <-peer.Disconnect:
@@ -219,28 +559,90 @@ func (s *Service) monitorPeerConnection(peer *PeerConnection) {
 	}
 }
 
-// UpdatePeerStatus updates a peer's last seen timestamp
-func (s *Service) UpdatePeerStatus(ctx context.Context, peerID string) error {
+// UpdatePeerStatus refreshes a peer's liveness and applies any endpoint or
+// library changes reported with its heartbeat. Peers whose session was restored
+// after a server restart are resumed by AuthenticatePeer first.
+func (s *Service) UpdatePeerStatus(ctx context.Context, peerID string, update *PeerStatusUpdate) error {
+	now := time.Now()
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	peer, exists := s.peers[peerID]
+	if !exists {
+		peer, exists = s.superPeers[peerID]
+	}
+	s.mu.Unlock()
+
+	if !exists {
+		return ErrPeerNotFound
+	}
 
 	// Update in-memory state
-	if peer, exists := s.peers[peerID]; exists {
-		peer.LastPing = time.Now()
-	} else if peer, exists := s.superPeers[peerID]; exists {
-		peer.LastPing = time.Now()
-	} else {
-		return fmt.Errorf("peer not found")
+	s.mu.Lock()
+	peer.LastPing = now
+	if update != nil {
+		if update.IPAddress != "" && update.IPAddress != peer.IPAddress {
+			peer.IPAddress = update.IPAddress
+			peer.LastProbe = time.Time{} // Moved; reachability has to be checked again
+		}
+		if update.ListenPort > 0 && update.ListenPort != peer.ListenPort {
+			peer.ListenPort = update.ListenPort
+			peer.LastProbe = time.Time{}
+		}
+		if update.SharedFileCount != nil {
+			peer.SharedFileCount = *update.SharedFileCount
+		}
+		if update.Uptime > 0 {
+			peer.ReportedUptime = update.Uptime
+		}
+		if update.UploadBandwidth > 0 {
+			peer.UploadBandwidth = update.UploadBandwidth
+		}
+		if update.Reachable != nil {
+			peer.Reachable = *update.Reachable
+		}
+		if update.ActiveUploads != nil {
+			peer.ActiveUploads = *update.ActiveUploads
+		}
+		if update.MaxUploads > 0 {
+			peer.MaxUploads = update.MaxUploads
+		}
+		if update.Latency > 0 {
+			peer.LatencyHint = update.Latency
+		}
+		if update.Connectivity != "" {
+			peer.ReportedConnectivity = update.Connectivity
+		}
+	}
+	session := sessionFromConnection(peer)
+	probe := s.needsProbe(peer)
+	s.mu.Unlock()
+
+	if probe {
+		go s.probePeer(peer)
 	}
 
 	// Update database
-	if err := s.db.GetDB().Model(&db.User{}).Where("id = ?", peerID).Update("last_seen", time.Now()).Error; err != nil {
+	if err := s.db.GetDB().Model(&db.User{}).Where("id = ?", peerID).Update("last_seen", now).Error; err != nil {
 		return fmt.Errorf("failed to update peer status: %w", err)
 	}
+	if err := s.db.GetDB().Model(session).Select("ip_address", "listen_port", "last_ping").Updates(session).Error; err != nil {
+		return fmt.Errorf("failed to update peer session: %w", err)
+	}
 
 	return nil
 }
 
+// NextHeartbeatInterval returns how long a peer should wait before its next heartbeat.
+// It follows HeartbeatInterval but never lets a peer wait longer than half the
+// connection timeout, so a single lost heartbeat does not get it evicted.
+func (s *Service) NextHeartbeatInterval() time.Duration {
+	interval := time.Duration(s.cfg.HeartbeatInterval) * time.Second
+	if limit := time.Duration(s.cfg.ConnectionTimeout) * time.Second / 2; limit > 0 && (interval <= 0 || interval > limit) {
+		interval = limit
+	}
+	return interval
+}
+
 // FileSearchResult combines file details with the peer's contact information.
 // type FileSearchResult struct {
 // 	db.File
@@ -255,7 +657,25 @@ type GetActivePeersDTO struct {
 	IPAddress     string    `json:"ipAddress"`
 	ListenPort    int       `json:"listenPort"`
 	LastSeen      time.Time `json:"lastSeen"`
-	// Add other fields your frontend Peer type expects, like sharedFilesCount
+	SharedFiles   int       `json:"sharedFilesCount"`
+	Role          string    `json:"role"`
+	Connectivity  string    `json:"connectivity"`
+}
+
+// peerDTO describes a connection the way clients see it.
+// Must be called with the service lock held.
+func peerDTO(conn *PeerConnection) GetActivePeersDTO {
+	return GetActivePeersDTO{
+		ID:            conn.User.ID,
+		Username:      conn.User.Username,
+		IsSuperClient: conn.User.IsSuper,
+		IPAddress:     conn.IPAddress,
+		ListenPort:    conn.ListenPort,
+		LastSeen:      conn.LastPing, // or conn.User.LastSeen if that's more accurate
+		SharedFiles:   conn.sharedFileCount(),
+		Role:          conn.role(),
+		Connectivity:  conn.Connectivity,
+	}
 }
 
 // GetActivePeers retrieves a list of currently active peers.
@@ -267,28 +687,19 @@ func (s *Service) GetActivePeers(ctx context.Context) ([]GetActivePeersDTO, erro
 
 	for _, conn := range s.peers {
 		if conn.IsActive {
-			activePeers = append(activePeers, GetActivePeersDTO{
-				ID:            conn.User.ID,
-				Username:      conn.User.Username,
-				IsSuperClient: conn.User.IsSuper,
-				IPAddress:     conn.IPAddress,
-				ListenPort:    conn.ListenPort,
-				LastSeen:      conn.LastPing, // or conn.User.LastSeen if that's more accurate
-			})
+			activePeers = append(activePeers, peerDTO(conn))
 		}
 	}
 	for _, conn := range s.superPeers {
 		if conn.IsActive {
-			activePeers = append(activePeers, GetActivePeersDTO{
-				ID:            conn.User.ID,
-				Username:      conn.User.Username,
-				IsSuperClient: conn.User.IsSuper,
-				IPAddress:     conn.IPAddress,
-				ListenPort:    conn.ListenPort,
-				LastSeen:      conn.LastPing,
-			})
+			activePeers = append(activePeers, peerDTO(conn))
 		}
 	}
-	s.logger.Info("Retrieved active peers", zap.Int("count", len(activePeers)))
+	s.logger.Debug("Retrieved active peers", zap.Int("count", len(activePeers)))
 	return activePeers, nil
 }
+
+// Events returns the bus presence and sharing transitions are published on
+func (s *Service) Events() *EventBus {
+	return s.events
+}
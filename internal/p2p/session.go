@@ -0,0 +1,157 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+)
+
+// sessionFromConnection builds the persisted form of a peer connection.
+// Must be called with the service lock held.
+func sessionFromConnection(conn *PeerConnection) *db.PeerSession {
+	return &db.PeerSession{
+		PeerID:       conn.User.ID,
+		IPAddress:    conn.IPAddress,
+		ListenPort:   conn.ListenPort,
+		IsSuper:      conn.User.IsSuper,
+		Capabilities: strings.Join(conn.Capabilities, ","),
+		LastPing:     conn.LastPing,
+	}
+}
+
+// saveSession writes (or overwrites) the persisted session of a connected peer
+func (s *Service) saveSession(conn *PeerConnection) error {
+	s.mu.RLock()
+	session := sessionFromConnection(conn)
+	s.mu.RUnlock()
+
+	if err := s.db.GetDB().Save(session).Error; err != nil {
+		return fmt.Errorf("failed to save peer session: %w", err)
+	}
+	return nil
+}
+
+// deleteSession forgets the persisted session of a peer that left or timed out
+func (s *Service) deleteSession(peerID string) {
+	if err := s.db.GetDB().Delete(&db.PeerSession{}, "peer_id = ?", peerID).Error; err != nil {
+		s.logger.Error("Failed to delete peer session", zap.Error(err), zap.String("peer_id", peerID))
+	}
+}
+
+// restoreSessions loads the sessions persisted before the last shutdown. They stay
+// resumable for SessionGracePeriod; peers that do not heartbeat in that window are
+// forgotten and have to join again.
+func (s *Service) restoreSessions() {
+	var sessions []*db.PeerSession
+	if err := s.db.GetDB().Find(&sessions).Error; err != nil {
+		s.logger.Error("Failed to load persisted peer sessions", zap.Error(err))
+		return
+	}
+	if len(sessions) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	for _, session := range sessions {
+		s.resumable[session.PeerID] = session
+	}
+	s.mu.Unlock()
+
+	grace := time.Duration(s.cfg.SessionGracePeriod) * time.Second
+	s.logger.Info("Restored peer sessions awaiting resume",
+		zap.Int("count", len(sessions)),
+		zap.Duration("grace_period", grace))
+
+	time.AfterFunc(grace, s.expireRestoredSessions)
+}
+
+// expireRestoredSessions drops every restored session that was not resumed in time
+func (s *Service) expireRestoredSessions() {
+	s.mu.Lock()
+	expired := make([]string, 0, len(s.resumable))
+	for peerID := range s.resumable {
+		expired = append(expired, peerID)
+	}
+	s.resumable = make(map[string]*db.PeerSession)
+	s.mu.Unlock()
+
+	if len(expired) == 0 {
+		return
+	}
+
+	if err := s.db.GetDB().Where("peer_id IN ?", expired).Delete(&db.PeerSession{}).Error; err != nil {
+		s.logger.Error("Failed to delete expired peer sessions", zap.Error(err))
+		return
+	}
+	s.logger.Info("Expired peer sessions that were not resumed", zap.Int("count", len(expired)))
+}
+
+// resumeSession brings a restored session back online under its original peer ID,
+// together with the files the peer had shared before the restart. The peer has to
+// present its credential, and takes a slot like any joining peer.
+func (s *Service) resumeSession(ctx context.Context, peerID, credential string) (*PeerConnection, error) {
+	s.mu.RLock()
+	_, ok := s.resumable[peerID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrPeerNotFound
+	}
+
+	found, err := s.verifyCredential(peerID, credential)
+	if err != nil {
+		return nil, err
+	}
+	user := *found
+
+	// Claim the session so concurrent requests cannot resume it twice
+	s.mu.Lock()
+	session, ok := s.resumable[peerID]
+	if ok {
+		delete(s.resumable, peerID)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrPeerNotFound
+	}
+
+	if err := s.acquireSlot(ctx, user.IsSuper); err != nil {
+		// The session is gone either way; the peer has to join again
+		s.deleteSession(peerID)
+		return nil, err
+	}
+	joined := false
+	defer func() { s.releaseSlot(user.IsSuper, joined) }()
+
+	files, err := s.loadSharedFiles(peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &PeerConnection{
+		User:         &user,
+		IPAddress:    session.IPAddress,
+		ListenPort:   session.ListenPort,
+		LastPing:     time.Now(),
+		Files:        files,
+		IsActive:     true,
+		Disconnect:   make(chan struct{}),
+		ConnectedAt:  time.Now(),
+		Connectivity: ConnectivityUnknown,
+		credential:   credentialDigest(credential),
+	}
+	if session.Capabilities != "" {
+		conn.Capabilities = strings.Split(session.Capabilities, ",")
+	}
+	s.attachConnection(conn)
+	joined = true
+
+	s.logger.Info("Peer resumed session after restart",
+		zap.String("peer_id", peerID),
+		zap.String("username", user.Username),
+		zap.Int("files", len(files)))
+	return conn, nil
+}
@@ -0,0 +1,98 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/inventor7/p2p/internal/db"
+	"go.uber.org/zap"
+)
+
+// SwarmSource is an online peer holding a copy of a file
+type SwarmSource struct {
+	PeerID       string `json:"peer_id"`
+	FileID       string `json:"file_id"`
+	FileName     string `json:"file_name"` // Peers may share the same content under different names
+	IPAddress    string `json:"ip_address"`
+	ListenPort   int    `json:"listen_port"`
+	Connectivity string `json:"connectivity"`
+	Cached       bool   `json:"cached,omitempty"` // Served from the super peer's cache rather than a peer
+	// How good a source the peer is right now, from 0 to 100; sources are ordered by it
+	Score float64 `json:"score"`
+}
+
+// Swarm groups every online peer sharing the same content
+type Swarm struct {
+	Hash    string        `json:"hash"`
+	Size    int64         `json:"size"`
+	Sources []SwarmSource `json:"sources"`
+}
+
+// SwarmLookup returns the online peers sharing the file with the given hash, so a
+// client can download different chunks from several of them at once. A super peer
+// caching the file is listed as an additional source.
+func (s *Service) SwarmLookup(ctx context.Context, hash string) (*Swarm, error) {
+	swarm, err := s.swarmSources(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordDemand(demandSwarm, hash)
+	if s.touchCache(hash) {
+		host, port := s.cacheEndpoint()
+		swarm.Sources = append(swarm.Sources, SwarmSource{
+			PeerID:       s.cfg.ServerID,
+			IPAddress:    host,
+			ListenPort:   port,
+			Connectivity: ConnectivityPublic,
+			Cached:       true,
+			Score:        cacheSourceScore(),
+		})
+	}
+
+	s.logger.Debug("Looked up swarm", zap.String("hash", hash), zap.Int("sources", len(swarm.Sources)))
+	return swarm, nil
+}
+
+// swarmSources returns the online peers holding a file, best source first
+func (s *Service) swarmSources(hash string) (*Swarm, error) {
+	var files []*db.File
+	if err := s.db.GetDB().Where("hash = ?", hash).Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up swarm: %w", err)
+	}
+
+	swarm := &Swarm{Hash: hash, Sources: []SwarmSource{}}
+	if len(files) > 0 {
+		swarm.Size = files[0].Size
+	}
+	seen := make(map[string]bool)
+
+	s.mu.RLock()
+	for _, file := range files {
+		conn, ok := s.peers[file.OwnerID]
+		if !ok {
+			conn, ok = s.superPeers[file.OwnerID]
+		}
+		if !ok || !conn.IsActive || seen[file.OwnerID] {
+			continue
+		}
+		seen[file.OwnerID] = true
+
+		swarm.Sources = append(swarm.Sources, SwarmSource{
+			PeerID:       file.OwnerID,
+			FileID:       file.ID,
+			FileName:     file.Name,
+			IPAddress:    conn.IPAddress,
+			ListenPort:   conn.ListenPort,
+			Connectivity: conn.Connectivity,
+			Score:        conn.sourceScore(),
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(swarm.Sources, func(i, j int) bool {
+		return swarm.Sources[i].Score > swarm.Sources[j].Score
+	})
+	return swarm, nil
+}
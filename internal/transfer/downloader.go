@@ -0,0 +1,212 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// partSuffix marks a download in progress; it is renamed once verified
+const partSuffix = ".part"
+
+// ErrFileTooLarge is returned when a peer's manifest describes a file above the downloader's size limit
+var ErrFileTooLarge = errors.New("file exceeds the download size limit")
+
+// Downloader fetches files from peers running a Server
+type Downloader struct {
+	client *http.Client
+	logger *zap.Logger
+
+	MaxSize int64 // Largest file accepted, 0 for no limit
+}
+
+// NewDownloader creates a downloader using the given HTTP client
+func NewDownloader(client *http.Client, logger *zap.Logger) *Downloader {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Downloader{
+		client: client,
+		logger: logger,
+	}
+}
+
+// FetchManifest retrieves the manifest of a file from a peer
+func (d *Downloader) FetchManifest(ctx context.Context, baseURL, hash string) (*Manifest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL(baseURL, hash)+"/manifest", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest request: %w", err)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch manifest: peer returned %s", resp.Status)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+	// A download never grows past its manifest's size, so this bounds what is written
+	if d.MaxSize > 0 && manifest.Size > d.MaxSize {
+		return nil, fmt.Errorf("manifest lists %d bytes: %w", manifest.Size, ErrFileTooLarge)
+	}
+	if !strings.EqualFold(manifest.FileHash, hash) {
+		return nil, fmt.Errorf("manifest is for %s: %w", manifest.FileHash, ErrFileMismatch)
+	}
+	return &manifest, nil
+}
+
+// FetchChunk downloads and verifies a single chunk from a peer
+func (d *Downloader) FetchChunk(ctx context.Context, baseURL string, manifest *Manifest, index int) ([]byte, error) {
+	url := fmt.Sprintf("%s/chunks/%d", fileURL(baseURL, manifest.FileHash), index)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk request: %w", err)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chunk %d: %w", index, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch chunk %d: peer returned %s", index, resp.Status)
+	}
+
+	_, length := manifest.ChunkRange(index)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, length+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %d: %w", index, err)
+	}
+	if !manifest.VerifyChunk(index, data) {
+		return nil, fmt.Errorf("chunk %d: %w", index, ErrChunkMismatch)
+	}
+	return data, nil
+}
+
+// Download fetches the file with the given hash from a peer into dest. Data is
+// written to dest.part first; if that file exists from an interrupted attempt its
+// intact chunks are kept and only the missing ones are fetched. The finished file
+// is verified against expectedHash before it is moved into place.
+func (d *Downloader) Download(ctx context.Context, baseURL, expectedHash, dest string) error {
+	manifest, err := d.FetchManifest(ctx, baseURL, expectedHash)
+	if err != nil {
+		return err
+	}
+
+	part, missing, err := OpenPartial(dest, manifest)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	d.logger.Debug("Downloading file",
+		zap.String("hash", expectedHash),
+		zap.Int("chunks", manifest.ChunkCount()),
+		zap.Int("missing", len(missing)))
+
+	for _, index := range missing {
+		data, err := d.FetchChunk(ctx, baseURL, manifest, index)
+		if err != nil {
+			return err
+		}
+		offset, _ := manifest.ChunkRange(index)
+		if _, err := part.WriteAt(data, offset); err != nil {
+			return fmt.Errorf("failed to write chunk %d: %w", index, err)
+		}
+	}
+
+	return FinishPartial(part, dest, expectedHash)
+}
+
+// OpenPartial opens (or creates) the partial download for dest and returns the
+// indexes of the chunks that still need to be fetched
+func OpenPartial(dest string, manifest *Manifest) (*os.File, []int, error) {
+	part, err := os.OpenFile(dest+partSuffix, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open partial download: %w", err)
+	}
+
+	info, err := part.Stat()
+	if err != nil {
+		part.Close()
+		return nil, nil, fmt.Errorf("failed to stat partial download: %w", err)
+	}
+	existing := info.Size()
+
+	if err := part.Truncate(manifest.Size); err != nil {
+		part.Close()
+		return nil, nil, fmt.Errorf("failed to size partial download: %w", err)
+	}
+
+	var missing []int
+	buf := make([]byte, manifest.ChunkSize)
+	for index := 0; index < manifest.ChunkCount(); index++ {
+		offset, length := manifest.ChunkRange(index)
+		if offset+length > existing {
+			missing = append(missing, index)
+			continue
+		}
+		if _, err := part.ReadAt(buf[:length], offset); err != nil || !manifest.VerifyChunk(index, buf[:length]) {
+			missing = append(missing, index)
+		}
+	}
+	return part, missing, nil
+}
+
+// FinishPartial verifies a completed partial download against expectedHash and
+// renames it to dest. A partial file that fails verification is removed.
+func FinishPartial(part *os.File, dest, expectedHash string) error {
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind partial download: %w", err)
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, part); err != nil {
+		return fmt.Errorf("failed to hash download: %w", err)
+	}
+	if err := part.Sync(); err != nil {
+		return fmt.Errorf("failed to flush download: %w", err)
+	}
+	part.Close()
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(actual, expectedHash) {
+		os.Remove(part.Name())
+		return fmt.Errorf("got %s, expected %s: %w", actual, expectedHash, ErrFileMismatch)
+	}
+
+	if err := os.Rename(part.Name(), dest); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	return nil
+}
+
+// IsIntegrityError reports whether err means the data received was corrupt
+// rather than that the transfer failed
+func IsIntegrityError(err error) bool {
+	return errors.Is(err, ErrChunkMismatch) || errors.Is(err, ErrFileMismatch)
+}
+
+// fileURL returns the URL of a file on a peer's server
+func fileURL(baseURL, hash string) string {
+	return strings.TrimRight(baseURL, "/") + "/files/" + hash
+}
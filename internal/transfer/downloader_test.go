@@ -0,0 +1,189 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const testChunkSize = 1024
+
+// serveTestFile writes size bytes of random data to a file and serves it. The
+// returned handler can be wrapped to interfere with the transfer.
+func serveTestFile(t *testing.T, size int) ([]byte, *Manifest, http.Handler) {
+	t.Helper()
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	path := filepath.Join(t.TempDir(), "shared.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	server := NewServer(testChunkSize, zap.NewNop())
+	manifest, err := server.AddFile(path)
+	if err != nil {
+		t.Fatalf("failed to serve test file: %v", err)
+	}
+	return data, manifest, server
+}
+
+func isChunkRequest(r *http.Request) bool {
+	return strings.Contains(r.URL.Path, "/chunks/")
+}
+
+func TestDownloadResumesAfterInterruption(t *testing.T) {
+	data, manifest, server := serveTestFile(t, 5*testChunkSize+100)
+
+	var served, limit atomic.Int64
+	limit.Store(2)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isChunkRequest(r) {
+			if served.Load() >= limit.Load() {
+				http.Error(w, "peer went away", http.StatusServiceUnavailable)
+				return
+			}
+			served.Add(1)
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer peer.Close()
+
+	dest := filepath.Join(t.TempDir(), "download.bin")
+	downloader := NewDownloader(peer.Client(), zap.NewNop())
+
+	if err := downloader.Download(context.Background(), peer.URL, manifest.FileHash, dest); err == nil {
+		t.Fatal("expected the interrupted download to fail")
+	}
+	if _, err := os.Stat(dest + partSuffix); err != nil {
+		t.Fatalf("expected the partial download to be kept: %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected no completed file, got %v", err)
+	}
+
+	served.Store(0)
+	limit.Store(int64(manifest.ChunkCount()))
+	if err := downloader.Download(context.Background(), peer.URL, manifest.FileHash, dest); err != nil {
+		t.Fatalf("resumed download failed: %v", err)
+	}
+	if want := int64(manifest.ChunkCount() - 2); served.Load() != want {
+		t.Errorf("resumed download fetched %d chunks, want %d", served.Load(), want)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from the original")
+	}
+	if _, err := os.Stat(dest + partSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the partial download to be gone, got %v", err)
+	}
+}
+
+// corruptChunk serves the given chunk with one byte flipped
+func corruptChunk(server http.Handler, index string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/chunks/"+index) {
+			server.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, r)
+		body := rec.Body.Bytes()
+		body[0] ^= 0xff
+		w.Write(body)
+	})
+}
+
+func TestDownloadRejectsCorruptChunk(t *testing.T) {
+	_, manifest, server := serveTestFile(t, 3*testChunkSize)
+
+	peer := httptest.NewServer(corruptChunk(server, "1"))
+	defer peer.Close()
+
+	dest := filepath.Join(t.TempDir(), "download.bin")
+	downloader := NewDownloader(peer.Client(), zap.NewNop())
+
+	err := downloader.Download(context.Background(), peer.URL, manifest.FileHash, dest)
+	if !errors.Is(err, ErrChunkMismatch) {
+		t.Fatalf("expected ErrChunkMismatch, got %v", err)
+	}
+	if !IsIntegrityError(err) {
+		t.Error("expected a corrupt chunk to count as an integrity error")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("expected no completed file, got %v", err)
+	}
+}
+
+func TestSwarmDownloadBansCorruptSource(t *testing.T) {
+	data, manifest, server := serveTestFile(t, 8*testChunkSize)
+
+	var corruptServed atomic.Int64
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isChunkRequest(r) {
+			corruptServed.Add(1)
+			w.Write(bytes.Repeat([]byte{0}, testChunkSize))
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer corrupt.Close()
+	good := httptest.NewServer(server)
+	defer good.Close()
+
+	dest := filepath.Join(t.TempDir(), "download.bin")
+	swarm := NewSwarmDownloader(NewDownloader(nil, zap.NewNop()), zap.NewNop())
+	swarm.WorkersPerSource = 1
+	sources := []Source{
+		{PeerID: "corrupt", BaseURL: corrupt.URL},
+		{PeerID: "good", BaseURL: good.URL},
+	}
+
+	if err := swarm.Download(context.Background(), sources, manifest.FileHash, dest); err != nil {
+		t.Fatalf("swarm download failed: %v", err)
+	}
+	if corruptServed.Load() > 1 {
+		t.Errorf("corrupt source was asked for %d chunks after sending bad data", corruptServed.Load())
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from the original")
+	}
+}
+
+func TestDownloadRespectsSizeLimit(t *testing.T) {
+	_, manifest, server := serveTestFile(t, 4*testChunkSize)
+
+	peer := httptest.NewServer(server)
+	defer peer.Close()
+
+	dest := filepath.Join(t.TempDir(), "download.bin")
+	downloader := NewDownloader(peer.Client(), zap.NewNop())
+	downloader.MaxSize = manifest.Size - 1
+
+	err := downloader.Download(context.Background(), peer.URL, manifest.FileHash, dest)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+	if _, err := os.Stat(dest + partSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written, got %v", err)
+	}
+}
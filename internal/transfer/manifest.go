@@ -0,0 +1,156 @@
+// Package transfer implements the peer-to-peer wire protocol for moving file
+// contents. The super peer only brokers metadata; peers serve their shared files
+// over HTTP with Server and fetch them from each other with Downloader.
+//
+// A file is addressed by its hash (hex-encoded SHA-256, the same value stored in
+// db.File.Hash) and split into fixed-size chunks, each with its own Merkle leaf
+// hash, so that a download can be verified piece by piece and resumed after an
+// interruption.
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultChunkSize is the chunk size used when none is configured
+const DefaultChunkSize int64 = 1 << 20 // 1MB
+
+// MaxChunkSize bounds the chunk size accepted from a peer's manifest
+const MaxChunkSize int64 = 64 << 20 // 64MB
+
+var (
+	// ErrChunkMismatch is returned when a chunk does not match its hash in the manifest
+	ErrChunkMismatch = errors.New("chunk hash mismatch")
+	// ErrFileMismatch is returned when a completed download does not match the expected file hash
+	ErrFileMismatch = errors.New("file hash mismatch")
+)
+
+// Manifest describes how a file is split into chunks
+type Manifest struct {
+	FileHash   string   `json:"file_hash"`
+	Size       int64    `json:"size"`
+	ChunkSize  int64    `json:"chunk_size"`
+	Chunks     []string `json:"chunks"`      // Hex-encoded leaf hash of each chunk, in order
+	Algorithm  string   `json:"algorithm"`   // Hash algorithm of the chunks and Merkle root
+	MerkleRoot string   `json:"merkle_root"` // Root of the Merkle tree over Chunks
+}
+
+// BuildManifest reads a file's contents and computes its chunk and file hashes
+func BuildManifest(r io.Reader, chunkSize int64) (*Manifest, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	manifest := &Manifest{ChunkSize: chunkSize, Algorithm: HashSHA256}
+	whole := sha256.New()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			whole.Write(buf[:n])
+			leaf, _ := LeafHash(HashSHA256, buf[:n]) // SHA-256 is always available
+			manifest.Chunks = append(manifest.Chunks, hex.EncodeToString(leaf))
+			manifest.Size += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file contents: %w", err)
+		}
+	}
+
+	manifest.FileHash = hex.EncodeToString(whole.Sum(nil))
+
+	tree, err := BuildMerkleTreeHex(HashSHA256, manifest.Chunks)
+	if err != nil {
+		return nil, err
+	}
+	manifest.MerkleRoot = tree.Root()
+	return manifest, nil
+}
+
+// algorithm returns the hash algorithm of the chunks; manifests that do not name
+// one use SHA-256
+func (m *Manifest) algorithm() string {
+	if m.Algorithm == "" {
+		return HashSHA256
+	}
+	return m.Algorithm
+}
+
+// ChunkCount returns the number of chunks in the file
+func (m *Manifest) ChunkCount() int {
+	return len(m.Chunks)
+}
+
+// ChunkRange returns the byte offset and length of a chunk
+func (m *Manifest) ChunkRange(index int) (offset, length int64) {
+	offset = int64(index) * m.ChunkSize
+	length = m.ChunkSize
+	if offset+length > m.Size {
+		length = m.Size - offset
+	}
+	return offset, length
+}
+
+// Validate checks that the manifest is internally consistent
+func (m *Manifest) Validate() error {
+	if m.ChunkSize <= 0 || m.ChunkSize > MaxChunkSize || m.Size < 0 {
+		return fmt.Errorf("invalid manifest: chunk size %d, size %d", m.ChunkSize, m.Size)
+	}
+	expected := int((m.Size + m.ChunkSize - 1) / m.ChunkSize)
+	if len(m.Chunks) != expected {
+		return fmt.Errorf("invalid manifest: %d chunks listed, %d expected", len(m.Chunks), expected)
+	}
+	h, err := NewHash(m.algorithm())
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	for i, chunk := range m.Chunks {
+		if len(chunk) != hex.EncodedLen(h.Size()) {
+			return fmt.Errorf("invalid manifest: chunk %d hash is not a %s hash", i, m.algorithm())
+		}
+	}
+	if m.MerkleRoot != "" {
+		return m.VerifyRoot(m.MerkleRoot)
+	}
+	return nil
+}
+
+// VerifyRoot checks that the manifest's chunk hashes produce the given Merkle
+// root, e.g. the root the owner announced to the super peer
+func (m *Manifest) VerifyRoot(root string) error {
+	tree, err := BuildMerkleTreeHex(m.algorithm(), m.Chunks)
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	if !strings.EqualFold(tree.Root(), root) {
+		return fmt.Errorf("manifest does not match merkle root %s: %w", root, ErrChunkMismatch)
+	}
+	return nil
+}
+
+// VerifyChunk reports whether data is the expected content of a chunk
+func (m *Manifest) VerifyChunk(index int, data []byte) bool {
+	if index < 0 || index >= len(m.Chunks) {
+		return false
+	}
+	_, length := m.ChunkRange(index)
+	if int64(len(data)) != length {
+		return false
+	}
+	leaf, err := LeafHash(m.algorithm(), data)
+	return err == nil && strings.EqualFold(hex.EncodeToString(leaf), m.Chunks[index])
+}
+
+// HashBytes returns the hex-encoded SHA-256 of data
+func HashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
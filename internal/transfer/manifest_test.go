@@ -0,0 +1,161 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testManifest(t *testing.T, size int) *Manifest {
+	t.Helper()
+
+	manifest, err := BuildManifest(bytes.NewReader(bytes.Repeat([]byte("peermili"), size/8)), testChunkSize)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	return manifest
+}
+
+func TestManifestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(m *Manifest)
+		valid   bool
+		wantErr error
+	}{
+		{
+			name:   "built manifest",
+			modify: func(m *Manifest) {},
+			valid:  true,
+		},
+		{
+			name:   "no algorithm or root",
+			modify: func(m *Manifest) { m.Algorithm, m.MerkleRoot = "", "" },
+			valid:  true,
+		},
+		{
+			name:   "missing chunk",
+			modify: func(m *Manifest) { m.Chunks = m.Chunks[1:] },
+		},
+		{
+			name:   "chunk size zero",
+			modify: func(m *Manifest) { m.ChunkSize = 0 },
+		},
+		{
+			name:   "chunk size too large",
+			modify: func(m *Manifest) { m.ChunkSize = MaxChunkSize + 1 },
+		},
+		{
+			name:   "negative size",
+			modify: func(m *Manifest) { m.Size = -1 },
+		},
+		{
+			name:    "unsupported algorithm",
+			modify:  func(m *Manifest) { m.Algorithm = "md5" },
+			wantErr: ErrUnsupportedAlgorithm,
+		},
+		{
+			name:   "truncated chunk hash",
+			modify: func(m *Manifest) { m.Chunks[0] = m.Chunks[0][:32] },
+		},
+		{
+			name:    "chunk hash not matching the root",
+			modify:  func(m *Manifest) { m.Chunks[1] = strings.Repeat("0", len(m.Chunks[1])) },
+			wantErr: ErrChunkMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := testManifest(t, 4*testChunkSize)
+			tt.modify(manifest)
+
+			err := manifest.Validate()
+			if tt.valid && err != nil {
+				t.Fatalf("expected a valid manifest, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected the manifest to be rejected")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestManifestValidateAlgorithms(t *testing.T) {
+	chunks := [][]byte{[]byte("first chunk"), []byte("second")}
+
+	for _, algorithm := range SupportedAlgorithms() {
+		t.Run(algorithm, func(t *testing.T) {
+			manifest := &Manifest{Size: 17, ChunkSize: 11, Algorithm: algorithm}
+			leaves := make([][]byte, len(chunks))
+			for i, chunk := range chunks {
+				leaf, err := LeafHash(algorithm, chunk)
+				if err != nil {
+					t.Fatalf("failed to hash chunk: %v", err)
+				}
+				leaves[i] = leaf
+				manifest.Chunks = append(manifest.Chunks, hex.EncodeToString(leaf))
+			}
+			tree, err := BuildMerkleTree(algorithm, leaves)
+			if err != nil {
+				t.Fatalf("failed to build tree: %v", err)
+			}
+			manifest.MerkleRoot = tree.Root()
+
+			if err := manifest.Validate(); err != nil {
+				t.Fatalf("expected a valid %s manifest, got %v", algorithm, err)
+			}
+			for i, chunk := range chunks {
+				if !manifest.VerifyChunk(i, chunk) {
+					t.Errorf("chunk %d did not verify", i)
+				}
+			}
+			if manifest.VerifyChunk(1, []byte("tamper")) {
+				t.Error("tampered chunk verified")
+			}
+		})
+	}
+}
+
+func TestVerifyProofChecksPath(t *testing.T) {
+	for leaves := 1; leaves <= 9; leaves++ {
+		chunks := make([][]byte, leaves)
+		hashes := make([][]byte, leaves)
+		for i := range chunks {
+			chunks[i] = []byte{byte(leaves), byte(i)}
+			hashes[i], _ = LeafHash(HashSHA256, chunks[i])
+		}
+		tree, err := BuildMerkleTree(HashSHA256, hashes)
+		if err != nil {
+			t.Fatalf("failed to build tree: %v", err)
+		}
+
+		for i := range chunks {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatalf("failed to build proof: %v", err)
+			}
+			if ok, err := VerifyProof(HashSHA256, tree.Root(), chunks[i], i, leaves, proof); !ok || err != nil {
+				t.Errorf("%d leaves: proof for chunk %d rejected (%v)", leaves, i, err)
+			}
+			for j := range chunks {
+				if j == i {
+					continue
+				}
+				if ok, _ := VerifyProof(HashSHA256, tree.Root(), chunks[i], j, leaves, proof); ok {
+					t.Errorf("%d leaves: proof for chunk %d accepted at index %d", leaves, i, j)
+				}
+			}
+			if len(proof) > 0 {
+				if ok, _ := VerifyProof(HashSHA256, tree.Root(), chunks[i], i, leaves, proof[:len(proof)-1]); ok {
+					t.Errorf("%d leaves: truncated proof for chunk %d accepted", leaves, i)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hash algorithms a Merkle root can be declared with
+const (
+	HashSHA256  = "sha256"
+	HashSHA512  = "sha512"
+	HashBLAKE2b = "blake2b-256"
+)
+
+// ErrUnsupportedAlgorithm is returned for hash algorithms this build cannot compute
+var ErrUnsupportedAlgorithm = errors.New("unsupported hash algorithm")
+
+// SupportedAlgorithms lists the hash algorithms accepted for Merkle roots
+func SupportedAlgorithms() []string {
+	return []string{HashSHA256, HashSHA512, HashBLAKE2b}
+}
+
+// NewHash returns a hash function for the named algorithm
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashBLAKE2b:
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("%q: %w", algorithm, ErrUnsupportedAlgorithm)
+	}
+}
+
+// ProofStep is one sibling on the path from a chunk to the Merkle root
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // Whether the sibling is hashed in on the left
+}
+
+// MerkleTree is a binary hash tree over a file's chunks. Leaves are the chunk
+// hashes H(0x00 || chunk), an inner node is H(0x01 || left || right), and a node
+// without a sibling is promoted to the next level unchanged. The prefixes keep a
+// leaf from being passed off as an inner node and the other way round.
+type MerkleTree struct {
+	leaves int
+	levels [][][]byte // levels[0] holds the leaves, the last level the root
+}
+
+// LeafHash returns the leaf of the Merkle tree for a chunk's data
+func LeafHash(algorithm string, chunk []byte) ([]byte, error) {
+	return hashParts(algorithm, []byte{0x00}, chunk)
+}
+
+// BuildMerkleTree builds a tree over the given leaf hashes
+func BuildMerkleTree(algorithm string, leaves [][]byte) (*MerkleTree, error) {
+	if _, err := NewHash(algorithm); err != nil {
+		return nil, err
+	}
+
+	tree := &MerkleTree{leaves: len(leaves)}
+	if len(leaves) == 0 {
+		// An empty file has no chunks; its root is the hash of nothing
+		empty, _ := hashParts(algorithm)
+		tree.levels = [][][]byte{{empty}}
+		return tree, nil
+	}
+
+	level := leaves
+	tree.levels = append(tree.levels, level)
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			node, err := hashParts(algorithm, []byte{0x01}, level[i], level[i+1])
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, node)
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree, nil
+}
+
+// BuildMerkleTreeHex builds a tree over hex-encoded leaf hashes
+func BuildMerkleTreeHex(algorithm string, leaves []string) (*MerkleTree, error) {
+	decoded := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		b, err := hex.DecodeString(leaf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk hash %d: %w", i, err)
+		}
+		decoded[i] = b
+	}
+	return BuildMerkleTree(algorithm, decoded)
+}
+
+// Root returns the hex-encoded root hash
+func (t *MerkleTree) Root() string {
+	return hex.EncodeToString(t.levels[len(t.levels)-1][0])
+}
+
+// LeafCount returns the number of chunks covered by the tree
+func (t *MerkleTree) LeafCount() int {
+	return t.leaves
+}
+
+// Proof returns the siblings needed to recompute the root from a chunk's hash
+func (t *MerkleTree) Proof(index int) ([]ProofStep, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, fmt.Errorf("chunk %d out of range", index)
+	}
+
+	proof := []ProofStep{}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, ProofStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < index,
+			})
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyProof checks that chunk data is the chunk at index in the tree with the
+// given root and number of leaves. The proof must take exactly the path from that
+// chunk to the root, so a valid proof for one chunk cannot vouch for another.
+func VerifyProof(algorithm, root string, chunk []byte, index, leaves int, proof []ProofStep) (bool, error) {
+	if index < 0 || index >= leaves {
+		return false, fmt.Errorf("chunk %d out of range", index)
+	}
+	node, err := LeafHash(algorithm, chunk)
+	if err != nil {
+		return false, err
+	}
+
+	steps := 0
+	for width := leaves; width > 1; width = (width + 1) / 2 {
+		sibling := index ^ 1
+		if sibling < width {
+			if steps == len(proof) || proof[steps].Left != (sibling < index) {
+				return false, nil
+			}
+			siblingHash, err := hex.DecodeString(proof[steps].Hash)
+			if err != nil {
+				return false, fmt.Errorf("invalid proof hash: %w", err)
+			}
+			if sibling < index {
+				node, err = hashParts(algorithm, []byte{0x01}, siblingHash, node)
+			} else {
+				node, err = hashParts(algorithm, []byte{0x01}, node, siblingHash)
+			}
+			if err != nil {
+				return false, err
+			}
+			steps++
+		}
+		// A node without a sibling is promoted unchanged
+		index /= 2
+	}
+	if steps != len(proof) {
+		return false, nil
+	}
+
+	expected, err := hex.DecodeString(root)
+	if err != nil {
+		return false, fmt.Errorf("invalid root hash: %w", err)
+	}
+	return bytes.Equal(node, expected), nil
+}
+
+// hashParts hashes the concatenation of parts with the named algorithm
+func hashParts(algorithm string, parts ...[]byte) ([]byte, error) {
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,173 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// servedFile is a local file offered to other peers
+type servedFile struct {
+	path     string
+	name     string
+	manifest *Manifest
+}
+
+// Server serves a peer's shared files to other peers:
+//
+//	GET /files/{hash}/manifest      chunk layout and hashes (JSON)
+//	GET /files/{hash}               whole file, with Range support
+//	GET /files/{hash}/chunks/{n}    a single chunk, hash in the X-Chunk-Hash header
+type Server struct {
+	chunkSize int64
+	logger    *zap.Logger
+	mux       *http.ServeMux
+
+	files map[string]*servedFile // Keyed by file hash
+	mu    sync.RWMutex
+}
+
+// NewServer creates a file server splitting files into chunks of chunkSize bytes
+func NewServer(chunkSize int64, logger *zap.Logger) *Server {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	s := &Server{
+		chunkSize: chunkSize,
+		logger:    logger,
+		mux:       http.NewServeMux(),
+		files:     make(map[string]*servedFile),
+	}
+	s.mux.HandleFunc("GET /files/{hash}/manifest", s.handleManifest)
+	s.mux.HandleFunc("GET /files/{hash}", s.handleFile)
+	s.mux.HandleFunc("GET /files/{hash}/chunks/{index}", s.handleChunk)
+	return s
+}
+
+// AddFile hashes a local file and starts serving it. The returned manifest's
+// FileHash is the value to share as the file's hash.
+func (s *Server) AddFile(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	manifest, err := BuildManifest(f, s.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.files[manifest.FileHash] = &servedFile{path: path, name: filepath.Base(path), manifest: manifest}
+	s.mu.Unlock()
+
+	s.logger.Debug("Serving file", zap.String("path", path), zap.String("hash", manifest.FileHash))
+	return manifest, nil
+}
+
+// RemoveFile stops serving a file
+func (s *Server) RemoveFile(hash string) {
+	s.mu.Lock()
+	delete(s.files, hash)
+	s.mu.Unlock()
+}
+
+// Manifest returns the manifest of a served file
+func (s *Server) Manifest(hash string) (*Manifest, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, ok := s.files[hash]
+	if !ok {
+		return nil, false
+	}
+	return file.manifest, true
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*servedFile, bool) {
+	s.mu.RLock()
+	file, ok := s.files[r.PathValue("hash")]
+	s.mu.RUnlock()
+
+	if !ok {
+		http.Error(w, "file not found", http.StatusNotFound)
+	}
+	return file, ok
+}
+
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file.manifest)
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		s.logger.Error("Failed to open served file", zap.Error(err), zap.String("path", file.path))
+		http.Error(w, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(file.manifest.FileHash))
+	http.ServeContent(w, r, file.name, info.ModTime(), f)
+}
+
+func (s *Server) handleChunk(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= file.manifest.ChunkCount() {
+		http.Error(w, "chunk not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		s.logger.Error("Failed to open served file", zap.Error(err), zap.String("path", file.path))
+		http.Error(w, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	offset, length := file.manifest.ChunkRange(index)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("X-Chunk-Hash", file.manifest.Chunks[index])
+	if _, err := io.Copy(w, io.NewSectionReader(f, offset, length)); err != nil {
+		s.logger.Debug("Chunk transfer interrupted", zap.Error(err), zap.Int("chunk", index))
+	}
+}
@@ -0,0 +1,319 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrNoSources is returned when every source of a swarm download failed
+var ErrNoSources = errors.New("no usable sources left")
+
+// Source is a peer serving a copy of the file being downloaded
+type Source struct {
+	PeerID  string
+	BaseURL string // e.g. http://ip:port of the peer's Server
+}
+
+// SwarmDownloader downloads a file from several peers in parallel. Chunks are
+// handed out from a shared queue, so fast sources naturally take more of them;
+// a chunk taking much longer than the best observed rate is abandoned and
+// requeued, and once the queue is empty idle sources duplicate chunks still in
+// flight on slower ones. Sources sending corrupt data or failing repeatedly are
+// banned for the rest of the download.
+type SwarmDownloader struct {
+	downloader *Downloader
+	logger     *zap.Logger
+
+	WorkersPerSource int           // Concurrent chunk requests per source
+	MaxFailures      int           // Failures after which a source is banned
+	MinChunkTimeout  time.Duration // Lower bound for the per-chunk deadline
+	SlowFactor       float64       // How many times slower than the best rate a chunk may be
+}
+
+// NewSwarmDownloader creates a swarm downloader on top of a Downloader
+func NewSwarmDownloader(downloader *Downloader, logger *zap.Logger) *SwarmDownloader {
+	return &SwarmDownloader{
+		downloader:       downloader,
+		logger:           logger,
+		WorkersPerSource: 2,
+		MaxFailures:      3,
+		MinChunkTimeout:  10 * time.Second,
+		SlowFactor:       4,
+	}
+}
+
+// swarmState is the chunk queue shared by all workers of one download
+type swarmState struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []int
+	inFlight  map[int]int // Chunk index to the number of workers fetching it
+	done      map[int]bool
+	remaining int
+	stopped   bool    // Set when the download is cancelled
+	bestRate  float64 // bytes per second
+}
+
+// sourceState tracks how reliable a source has been during one download
+type sourceState struct {
+	Source
+	mu       sync.Mutex
+	failures int
+	banned   bool
+}
+
+// fail records a failed chunk and reports whether the source is now banned
+func (s *sourceState) fail(err error, maxFailures int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	if IsIntegrityError(err) || s.failures >= maxFailures {
+		s.banned = true
+	}
+	return s.banned
+}
+
+func (s *sourceState) isBanned() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.banned
+}
+
+// next returns the chunk a worker should fetch, or false when the download is
+// complete. With nothing left in the queue it duplicates the chunk in flight on
+// the fewest workers, and waits when every chunk is already duplicated.
+func (st *swarmState) next() (int, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for {
+		if st.remaining == 0 || st.stopped {
+			return 0, false
+		}
+		for len(st.pending) > 0 {
+			index := st.pending[0]
+			st.pending = st.pending[1:]
+			if !st.done[index] {
+				st.inFlight[index]++
+				return index, true
+			}
+		}
+
+		candidate, fewest := -1, 2
+		for index, count := range st.inFlight {
+			if !st.done[index] && count < fewest {
+				candidate, fewest = index, count
+			}
+		}
+		if candidate >= 0 {
+			st.inFlight[candidate]++
+			return candidate, true
+		}
+		st.cond.Wait()
+	}
+}
+
+// release gives back a chunk a worker stopped fetching, requeueing it when no one
+// else is working on it
+func (st *swarmState) release(index int, completed bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.inFlight[index]--
+	if st.inFlight[index] <= 0 {
+		delete(st.inFlight, index)
+	}
+
+	if completed && !st.done[index] {
+		st.done[index] = true
+		st.remaining--
+	} else if !completed && !st.done[index] && st.inFlight[index] == 0 {
+		st.pending = append(st.pending, index)
+	}
+	st.cond.Broadcast()
+}
+
+// Download fetches the file with the given hash from the sources into dest,
+// resuming a previous partial download if there is one, and verifies the result
+// against expectedHash
+func (sd *SwarmDownloader) Download(ctx context.Context, sources []Source, expectedHash, dest string) error {
+	manifest, err := sd.FetchManifest(ctx, sources, expectedHash)
+	if err != nil {
+		return err
+	}
+	return sd.DownloadManifest(ctx, sources, manifest, dest)
+}
+
+// DownloadManifest fetches the file described by a manifest obtained from
+// FetchManifest, for callers that need to inspect the manifest first
+func (sd *SwarmDownloader) DownloadManifest(ctx context.Context, sources []Source, manifest *Manifest, dest string) error {
+	if len(sources) == 0 {
+		return ErrNoSources
+	}
+	if limit := sd.downloader.MaxSize; limit > 0 && manifest.Size > limit {
+		return fmt.Errorf("manifest lists %d bytes: %w", manifest.Size, ErrFileTooLarge)
+	}
+	expectedHash := manifest.FileHash
+
+	part, missing, err := OpenPartial(dest, manifest)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	st := &swarmState{
+		pending:   missing,
+		inFlight:  make(map[int]int),
+		done:      make(map[int]bool),
+		remaining: len(missing),
+	}
+	st.cond = sync.NewCond(&st.mu)
+
+	sd.logger.Info("Starting swarm download",
+		zap.String("hash", expectedHash),
+		zap.Int("sources", len(sources)),
+		zap.Int("missing_chunks", len(missing)))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := sd.WorkersPerSource
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		state := &sourceState{Source: source}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sd.work(ctx, st, state, manifest, part)
+			}()
+		}
+	}
+
+	// Wake workers blocked in next when the download is cancelled
+	go func() {
+		<-ctx.Done()
+		st.mu.Lock()
+		st.stopped = true
+		st.cond.Broadcast()
+		st.mu.Unlock()
+	}()
+
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	remaining := st.remaining
+	st.mu.Unlock()
+	if remaining > 0 {
+		return fmt.Errorf("%d chunks still missing: %w", remaining, ErrNoSources)
+	}
+
+	return FinishPartial(part, dest, expectedHash)
+}
+
+// FetchManifest returns the manifest from the first source that provides a valid one
+func (sd *SwarmDownloader) FetchManifest(ctx context.Context, sources []Source, hash string) (*Manifest, error) {
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+
+	var lastErr error
+	for _, source := range sources {
+		manifest, err := sd.downloader.FetchManifest(ctx, source.BaseURL, hash)
+		if err == nil {
+			return manifest, nil
+		}
+		sd.logger.Debug("Source could not provide manifest", zap.Error(err), zap.String("peer_id", source.PeerID))
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to fetch manifest from any source: %w", lastErr)
+}
+
+// work fetches chunks from one source until the download completes or the source is banned
+func (sd *SwarmDownloader) work(ctx context.Context, st *swarmState, source *sourceState, manifest *Manifest, part *os.File) {
+	for !source.isBanned() {
+		index, ok := st.next()
+		if !ok {
+			return
+		}
+
+		_, length := manifest.ChunkRange(index)
+		started := time.Now()
+		chunkCtx, cancel := context.WithTimeout(ctx, sd.chunkTimeout(st, length))
+		data, err := sd.downloader.FetchChunk(chunkCtx, source.BaseURL, manifest, index)
+		cancel()
+
+		if err != nil {
+			st.release(index, false)
+			if ctx.Err() != nil {
+				return
+			}
+
+			banned := source.fail(err, sd.MaxFailures)
+			sd.logger.Debug("Chunk fetch failed",
+				zap.Error(err),
+				zap.String("peer_id", source.PeerID),
+				zap.Int("chunk", index),
+				zap.Bool("banned", banned))
+			continue
+		}
+
+		sd.recordRate(st, length, time.Since(started))
+
+		st.mu.Lock()
+		alreadyDone := st.done[index]
+		st.mu.Unlock()
+		if !alreadyDone {
+			offset, _ := manifest.ChunkRange(index)
+			if _, err := part.WriteAt(data, offset); err != nil {
+				st.release(index, false)
+				sd.logger.Error("Failed to write chunk", zap.Error(err), zap.Int("chunk", index))
+				return
+			}
+		}
+		st.release(index, true)
+	}
+}
+
+// chunkTimeout allows a chunk SlowFactor times as long as the best rate seen so far
+func (sd *SwarmDownloader) chunkTimeout(st *swarmState, length int64) time.Duration {
+	st.mu.Lock()
+	rate := st.bestRate
+	st.mu.Unlock()
+
+	timeout := sd.MinChunkTimeout
+	if rate > 0 {
+		if expected := time.Duration(float64(length) / rate * sd.SlowFactor * float64(time.Second)); expected > timeout {
+			timeout = expected
+		}
+	}
+	return timeout
+}
+
+// recordRate updates the best transfer rate observed across sources
+func (sd *SwarmDownloader) recordRate(st *swarmState, length int64, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(length) / elapsed.Seconds()
+
+	st.mu.Lock()
+	if rate > st.bestRate {
+		st.bestRate = rate
+	}
+	st.mu.Unlock()
+}
@@ -52,19 +52,25 @@ func main() {
 	logger.Info("Database connected and migrations run successfully")
 
 	// --- Initialize Services ---
+	searchBackend, err := index.NewSearchBackend(cfg, database, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize search backend", zap.Error(err))
+	}
 	authSvc := auth.NewService(cfg, database, logger)
-	p2pSvc := p2p.NewService(cfg, database, logger)
-	indexSvc := index.NewService(cfg, database, logger)
+	p2pSvc := p2p.NewService(cfg, database, searchBackend, logger)
+	indexSvc := index.NewService(cfg, database, searchBackend, logger)
+	p2pSvc.OnFileShared(indexSvc.NotifyMatches) // Notify saved searches of newly shared files
 	logger.Info("All services initialized")
 
 	// --- Initialize Handlers ---
 	authHandler := api.NewAuthHandler(logger, authSvc)
 	indexHandler := api.NewIndexHandler(logger, indexSvc, p2pSvc)
 	p2pHandler := api.NewP2PHandler(logger, p2pSvc)
+	federationHandler := api.NewFederationHandler(cfg, logger, p2pSvc)
 	logger.Info("All handlers initialized")
 
 	// --- Initialize Router ---
-	router := api.NewRouter(cfg, logger, authHandler, indexHandler, p2pHandler)
+	router := api.NewRouter(cfg, logger, authHandler, indexHandler, p2pHandler, federationHandler)
 	ginEngine := router.Setup()
 	logger.Info("Router initialized and Gin engine setup complete")
 